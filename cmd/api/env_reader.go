@@ -2,6 +2,7 @@ package main
 
 import (
 	"strconv"
+	"time"
 )
 
 type EnvReader struct {
@@ -33,13 +34,24 @@ func (r EnvReader) GetInt(key string, defaultValue ...int) int {
 	return value
 }
 
-// func (r EnvReader) GetBool(key string, defaultValue ...bool) bool {
-// 	value, err := strconv.ParseBool(r.reader(key))
-// 	if err != nil {
-// 		if len(defaultValue) > 0 {
-// 			return defaultValue[0]
-// 		}
-// 		return false
-// 	}
-// 	return value
-// }
+func (r EnvReader) GetDuration(key string, defaultValue ...time.Duration) time.Duration {
+	value, err := time.ParseDuration(r.reader(key))
+	if err != nil {
+		if len(defaultValue) > 0 {
+			return defaultValue[0]
+		}
+		return 0
+	}
+	return value
+}
+
+func (r EnvReader) GetBool(key string, defaultValue ...bool) bool {
+	value, err := strconv.ParseBool(r.reader(key))
+	if err != nil {
+		if len(defaultValue) > 0 {
+			return defaultValue[0]
+		}
+		return false
+	}
+	return value
+}
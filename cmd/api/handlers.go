@@ -2,17 +2,37 @@ package main
 
 import (
 	"auth_api/internal/helpers"
+	"auth_api/internal/metrics"
+	"auth_api/internal/middleware"
 	"auth_api/internal/models"
+	"auth_api/internal/storage"
 	"auth_api/internal/validator"
+	"auth_api/internal/verify"
 	"database/sql"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+const (
+	grantTypeClientCredentials = "client_credentials"
+	grantTypeAuthorizationCode = "authorization_code"
+	grantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+	tokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+
+	scopeOpenID  = "openid"
+	scopeProfile = "profile"
+	scopeEmail   = "email"
+)
+
 // RegisterHandler create a user account with a hashed password
 func (app *Configs) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	var requestBody struct {
@@ -30,8 +50,9 @@ func (app *Configs) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	// validate data
 	requestBody.CheckRequired(requestBody.Email, "email")
 	requestBody.CheckRequired(requestBody.Password, "password")
-	requestBody.CheckRequired(requestBody.Role, "role")
 	requestBody.CheckValue(validator.IsEmail(requestBody.Email), "email", "valid email required")
+	// admins are created using the bootstrap admin or promoted by another admin
+	requestBody.CheckValue(requestBody.Role == "" || requestBody.Role == models.RoleUser, "role", "only USER accounts can be registered")
 
 	if !requestBody.Valid() {
 		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(requestBody.Error()))
@@ -51,7 +72,7 @@ func (app *Configs) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Hash and salt password
-	hashedPasswordBytes, err := app.PasswordEncryptor.GenerateHashedPassword(requestBody.Password)
+	hashedPasswordBytes, err := app.hashPassword(r.Context(), requestBody.Password)
 	if err != nil {
 		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(err.Error()))
 		return
@@ -63,7 +84,7 @@ func (app *Configs) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		Email:    requestBody.Email,
 		Password: string(hashedPasswordBytes),
 		Status:   models.UserStatusVerifyAccount,
-		Role:     requestBody.Role,
+		Role:     models.RoleUser,
 	}
 
 	err = app.DB.CreateUser(r.Context(), user)
@@ -72,6 +93,7 @@ func (app *Configs) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.Metrics.UserRegistered()
 	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(map[string]any{"message": "successfully created user"}))
 }
 
@@ -185,9 +207,10 @@ func (app *Configs) VerifyUserHandler(w http.ResponseWriter, r *http.Request) {
 	if verification.ExpiresAt.Before(time.Now()) || verification.AttemptsRemaining <= 0 {
 		err := app.DB.DeleteVerification(r.Context(), requestBody.Email)
 		if err != nil {
-			app.Logger.Error(err.Error())
+			app.logger(r).Error(err.Error())
 		}
 
+		app.Metrics.UserVerified(false)
 		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("user verification code has expired"))
 		return
 	}
@@ -200,20 +223,25 @@ func (app *Configs) VerifyUserHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		app.Metrics.UserVerified(false)
 		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("invalid user verification code"))
 		return
 	}
 
 	user.Status = models.UserStatusActive
-	if err := app.DB.UpdateUser(r.Context(), *user); err != nil {
+	err = app.DB.WithTx(r.Context(), func(repo storage.DBRepo) error {
+		if err := repo.UpdateUser(r.Context(), *user); err != nil {
+			return err
+		}
+
+		return repo.DeleteVerification(r.Context(), user.Email)
+	})
+	if err != nil {
 		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
 		return
 	}
 
-	if err := app.DB.DeleteVerification(r.Context(), user.Email); err != nil {
-		app.Logger.Error(err.Error())
-	}
-
+	app.Metrics.UserVerified(true)
 	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(nil))
 }
 
@@ -238,9 +266,22 @@ func (app *Configs) TokenHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	clientIP := app.TrustedProxies.ClientIP(r)
+	retryAfter, err := app.loginRetryAfter(r.Context(), body.Email, clientIP)
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	if retryAfter > 0 {
+		app.writeLoginLocked(w, retryAfter)
+		return
+	}
+
 	user, err := app.DB.GetUser(r.Context(), body.Email)
 	if errors.Is(err, sql.ErrNoRows) {
-		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("invalid email or password"))
+		app.compareUnknownUserPassword(r.Context(), body.Password)
+		app.writeLoginFailed(w, r, body.Email, clientIP)
 		return
 	}
 
@@ -249,30 +290,52 @@ func (app *Configs) TokenHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the password is checked first, so the status of an account is only revealed to callers that know its password
+	err = app.comparePassword(r.Context(), []byte(user.Password), []byte(body.Password))
+	if err != nil {
+		app.writeLoginFailed(w, r, body.Email, clientIP)
+		return
+	}
+
+	if err := app.resetLoginFailures(r.Context(), body.Email); err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
 	if user.Status != models.UserStatusActive {
+		app.Metrics.LoginFailed(metrics.LoginFailureNotActive)
 		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("user not active"))
 		return
 	}
 
-	err = app.PasswordEncryptor.CompareHashAndPassword([]byte(user.Password), []byte(body.Password))
+	tokenString, err := app.TokenUtils.GenerateToken(userClaims(user), app.AccessTokenTTL)
 	if err != nil {
-		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("invalid email or password"))
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse("auth token generation failed"))
 		return
 	}
 
-	tokenString, err := app.TokenUtils.GenerateToken(user.UserID, 24)
+	refreshTokenString, refreshToken, err := app.newRefreshToken(user.UserID, uuid.New().String())
 	if err != nil {
-		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse("auth token generation failed"))
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse("refresh token generation failed"))
+		return
+	}
+
+	if err := app.DB.CreateRefreshToken(r.Context(), refreshToken); err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
 		return
 	}
 
-	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(map[string]any{"token": tokenString}))
+	app.Metrics.LoginSucceeded()
+	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(map[string]any{"token": tokenString, "refresh_token": refreshTokenString}))
 }
 
-// DeleteUserHandler takes an email address and deletes the related user and verification data
-func (app *Configs) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+// SessionHandler signs a user in using a session cookie instead of a bearer token. The session is stored in the DB.
+// The CSRF token is returned and set as a cookie, and must be sent in the X-CSRF-Token header of requests that change
+// state
+func (app *Configs) SessionHandler(w http.ResponseWriter, r *http.Request) {
 	var body struct {
-		Email string `json:"email"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
 		validator.Validator
 	}
 
@@ -282,115 +345,107 @@ func (app *Configs) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	body.CheckRequired(body.Email, "email")
+	body.CheckRequired(body.Password, "password")
 	body.CheckValue(validator.IsEmail(body.Email), "email", "valid email required")
 	if !body.Valid() {
 		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(body.Error()))
 		return
 	}
 
-	recordsDeleted, err := app.DB.DeleteUser(r.Context(), body.Email)
+	clientIP := app.TrustedProxies.ClientIP(r)
+	retryAfter, err := app.loginRetryAfter(r.Context(), body.Email, clientIP)
 	if err != nil {
 		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
 		return
 	}
 
-	if !recordsDeleted {
-		helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(map[string]any{"message": "user not found"}))
+	if retryAfter > 0 {
+		app.writeLoginLocked(w, retryAfter)
 		return
 	}
 
-	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(nil))
-}
-
-func (app *Configs) ResetPasswordRequestHandler(w http.ResponseWriter, r *http.Request) {
-	var requestBody struct {
-		Email string `json:"email"`
-		validator.Validator
+	user, err := app.DB.GetUser(r.Context(), body.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.compareUnknownUserPassword(r.Context(), body.Password)
+		app.writeLoginFailed(w, r, body.Email, clientIP)
+		return
 	}
 
-	if err := helpers.ReadJSON(w, r, &requestBody); err != nil {
-		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("unable to parse json body"))
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
 		return
 	}
 
-	requestBody.CheckRequired(requestBody.Email, "email")
-	requestBody.CheckValue(validator.IsEmail(requestBody.Email), "email", "valid email required")
-	if !requestBody.Valid() {
-		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(requestBody.Error()))
+	// the password is checked first, so the status of an account is only revealed to callers that know its password
+	err = app.comparePassword(r.Context(), []byte(user.Password), []byte(body.Password))
+	if err != nil {
+		app.writeLoginFailed(w, r, body.Email, clientIP)
 		return
 	}
 
-	user, err := app.DB.GetUser(r.Context(), requestBody.Email)
-	if errors.Is(err, sql.ErrNoRows) {
-		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("user does not exist"))
+	if err := app.resetLoginFailures(r.Context(), body.Email); err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
 		return
 	}
 
 	if user.Status != models.UserStatusActive {
-		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("user is not active"))
+		app.Metrics.LoginFailed(metrics.LoginFailureNotActive)
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("user not active"))
 		return
 	}
 
-	verificationCode, err := app.Verifier.GenerateVerificationCode()
+	sessionToken, err := verify.GenerateOpaqueToken()
 	if err != nil {
-		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse("session generation failed"))
 		return
 	}
 
-	verification := models.Verification{
-		Email:             requestBody.Email,
-		VerificationType:  models.VerificationTypeReset,
-		VerificationCode:  verificationCode,
-		ExpiresAt:         time.Now().Add(time.Hour * 24),
-		AttemptsRemaining: app.Verifier.MaxRetries(),
+	csrfToken, err := verify.GenerateOpaqueToken()
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse("session generation failed"))
+		return
 	}
 
-	user.Status = models.UserStatusVerifyResetPassword
-	if err := app.DB.UpdateUser(r.Context(), *user); err != nil {
-		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
-		return
+	session := &models.Session{
+		SessionID:     uuid.New().String(),
+		TokenHash:     verify.HashOpaqueToken(sessionToken),
+		CSRFTokenHash: verify.HashOpaqueToken(csrfToken),
+		UserID:        user.UserID,
+		ExpiresAt:     time.Now().Add(app.SessionTTL),
 	}
 
-	if err := app.DB.InsertOrUpdateVerification(r.Context(), verification); err != nil {
+	if err := app.DB.CreateSession(r.Context(), session); err != nil {
 		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
 		return
 	}
 
-	var responseBody struct {
-		VerificationCode string `json:"verification_code"`
-	}
-
-	responseBody.VerificationCode = verificationCode
-
-	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(responseBody))
+	app.Metrics.LoginSucceeded()
+	setSessionCookies(w, sessionToken, csrfToken, session.ExpiresAt)
+	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(map[string]any{"csrf_token": csrfToken}))
 }
 
-func (app *Configs) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
-	var requestBody struct {
-		Email            string `json:"email"`
-		Password         string `json:"password"`
-		VerificationCode string `json:"verification_code"`
+// RefreshTokenHandler exchanges a refresh token for a new JWT and a new refresh token. Every refresh token can only be
+// used once. If an already rotated refresh token is presented, all tokens in its family are revoked
+func (app *Configs) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
 		validator.Validator
 	}
 
-	if err := helpers.ReadJSON(w, r, &requestBody); err != nil {
+	if err := helpers.ReadJSON(w, r, &body); err != nil {
 		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("unable to parse json body"))
 		return
 	}
 
-	requestBody.CheckRequired(requestBody.Email, "email")
-	requestBody.CheckRequired(requestBody.Password, "password")
-	requestBody.CheckValue(validator.IsEmail(requestBody.Email), "email", "valid email required")
-	requestBody.CheckRequired(requestBody.VerificationCode, "verification_code")
-
-	if !requestBody.Valid() {
-		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(requestBody.Error()))
+	body.CheckRequired(body.RefreshToken, "refresh_token")
+	if !body.Valid() {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(body.Error()))
 		return
 	}
 
-	user, err := app.DB.GetUser(r.Context(), requestBody.Email)
+	oldRefreshToken, err := app.DB.GetRefreshToken(r.Context(), verify.HashOpaqueToken(body.RefreshToken))
 	if errors.Is(err, sql.ErrNoRows) {
-		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("user does not exist"))
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("invalid refresh token"))
 		return
 	}
 
@@ -399,145 +454,185 @@ func (app *Configs) ResetPasswordHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if user.Status != models.UserStatusVerifyResetPassword {
-		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("password reset must first be requested"))
+	if oldRefreshToken.IsRevoked() {
+		// the token has already been used, so it might have been stolen. Revoke all tokens issued from the same login
+		if err := app.DB.RevokeRefreshTokenFamily(r.Context(), oldRefreshToken.FamilyID); err != nil {
+			app.logger(r).Error(err.Error())
+		}
+
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("invalid refresh token"))
 		return
 	}
 
-	verification, err := app.DB.GetVerification(r.Context(), models.VerificationTypeReset, requestBody.Email)
-	if errors.Is(err, sql.ErrNoRows) {
-		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(fmt.Sprintf("no password reset verification data found for user %s", requestBody.Email)))
+	if oldRefreshToken.ExpiresAt.Before(time.Now()) {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("refresh token has expired"))
 		return
 	}
 
+	user, err := app.DB.GetUserByID(r.Context(), oldRefreshToken.UserID)
 	if err != nil {
 		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
 		return
 	}
 
-	if verification.ExpiresAt.Before(time.Now()) || verification.AttemptsRemaining <= 0 {
-		err := app.DB.DeleteVerification(r.Context(), requestBody.Email)
-		if err != nil {
-			app.Logger.Error(err.Error())
-		}
-
-		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("password reset verification code has expired"))
+	if user.Status != models.UserStatusActive {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("user not active"))
 		return
 	}
 
-	if requestBody.VerificationCode != verification.VerificationCode {
-		if verification.AttemptsRemaining >= 0 {
-			if err := app.DB.InsertOrUpdateVerification(r.Context(), *verification); err != nil {
-				helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
-				return
-			}
-		}
-
-		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("invalid password reset verification code"))
+	tokenString, err := app.TokenUtils.GenerateToken(userClaims(user), app.AccessTokenTTL)
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse("auth token generation failed"))
 		return
 	}
 
-	hashedPasswordBytes, err := app.PasswordEncryptor.GenerateHashedPassword(requestBody.Password)
+	refreshTokenString, refreshToken, err := app.newRefreshToken(user.UserID, oldRefreshToken.FamilyID)
 	if err != nil {
-		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(err.Error()))
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse("refresh token generation failed"))
 		return
 	}
 
-	user.Status = models.UserStatusActive
-	user.Password = string(hashedPasswordBytes)
-	if err := app.DB.UpdateUser(r.Context(), *user); err != nil {
+	rotated, err := app.DB.RotateRefreshToken(r.Context(), oldRefreshToken.TokenID, refreshToken)
+	if err != nil {
 		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
 		return
 	}
 
-	if err := app.DB.DeleteVerification(r.Context(), user.Email); err != nil {
-		app.Logger.Error(err.Error())
+	if !rotated {
+		// another request rotated the token first
+		if err := app.DB.RevokeRefreshTokenFamily(r.Context(), oldRefreshToken.FamilyID); err != nil {
+			app.logger(r).Error(err.Error())
+		}
+
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("invalid refresh token"))
+		return
 	}
 
-	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(nil))
+	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(map[string]any{"token": tokenString, "refresh_token": refreshTokenString}))
 }
 
-func (app *Configs) UpdatePasswordHandler(w http.ResponseWriter, r *http.Request) {
+// LogoutHandler revokes the token used to call it. If a refresh token is provided, all the refresh tokens issued
+// from the same login are revoked as well
+func (app *Configs) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	var requestBody struct {
-		Email       string `json:"email"`
-		OldPassword string `json:"old_password"`
-		NewPassword string `json:"new_password"`
-		validator.Validator
+		RefreshToken string `json:"refresh_token"`
 	}
 
-	if err := helpers.ReadJSON(w, r, &requestBody); err != nil {
+	// the request body is optional
+	if err := helpers.ReadJSON(w, r, &requestBody); err != nil && !errors.Is(err, io.EOF) {
 		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("unable to parse json body"))
 		return
 	}
 
-	requestBody.CheckRequired(requestBody.Email, "email")
-	requestBody.CheckRequired(requestBody.OldPassword, "old password")
-	requestBody.CheckRequired(requestBody.NewPassword, "new password")
-	requestBody.CheckValue(validator.IsEmail(requestBody.Email), "email", "valid email required")
+	claims, ok := middleware.ClaimsFromContext(r.Context())
+	if !ok {
+		helpers.WriteJSON(w, http.StatusUnauthorized, helpers.ErrorResponse("token verification failed"))
+		return
+	}
 
-	if !requestBody.Valid() {
-		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(requestBody.Error()))
+	// callers that signed in using a session cookie end their session
+	if sessionID, ok := claims.Custom["sid"].(string); ok {
+		if err := app.DB.DeleteSession(r.Context(), sessionID); err != nil {
+			helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+			return
+		}
+
+		clearSessionCookies(w)
+		helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(map[string]any{"message": "successfully logged out"}))
 		return
 	}
 
-	user, err := app.DB.GetUser(r.Context(), requestBody.Email)
-	if errors.Is(err, sql.ErrNoRows) {
-		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("user does not exist"))
+	if claims.ID == "" {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("token can not be revoked"))
 		return
 	}
 
+	var familyID string
+	if requestBody.RefreshToken != "" {
+		refreshToken, err := app.DB.GetRefreshToken(r.Context(), verify.HashOpaqueToken(requestBody.RefreshToken))
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && refreshToken.UserID != claims.Subject) {
+			helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("invalid refresh token"))
+			return
+		}
+
+		if err != nil {
+			helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+			return
+		}
+
+		familyID = refreshToken.FamilyID
+	}
+
+	err := app.DB.WithTx(r.Context(), func(repo storage.DBRepo) error {
+		if familyID != "" {
+			if err := repo.RevokeRefreshTokenFamily(r.Context(), familyID); err != nil {
+				return err
+			}
+		}
+
+		return repo.RevokeToken(r.Context(), claims.ID, claims.ExpiresAt)
+	})
 	if err != nil {
 		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
 		return
 	}
 
-	if user.Status != models.UserStatusActive {
-		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("user not active"))
+	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(map[string]any{"message": "successfully logged out"}))
+}
+
+// LogoutAllHandler logs the caller out on all devices by revoking all their tokens, refresh tokens and sessions
+func (app *Configs) LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.ClaimsFromContext(r.Context())
+	if !ok {
+		helpers.WriteJSON(w, http.StatusUnauthorized, helpers.ErrorResponse("token verification failed"))
 		return
 	}
-	err = app.PasswordEncryptor.CompareHashAndPassword([]byte(user.Password), []byte(requestBody.OldPassword))
-	if err != nil {
-		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("old password is invalid"))
+
+	if !claims.IsUserToken() {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("token was not issued to a user"))
 		return
 	}
 
-	hashedPasswordBytes, err := app.PasswordEncryptor.GenerateHashedPassword(requestBody.NewPassword)
+	userFound, err := app.DB.IncrementTokenVersion(r.Context(), claims.Subject)
 	if err != nil {
-		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(err.Error()))
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
 		return
 	}
 
-	user.Password = string(hashedPasswordBytes)
-	if err := app.DB.UpdateUser(r.Context(), *user); err != nil {
-		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+	if !userFound {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("user does not exist"))
 		return
 	}
 
-	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(map[string]any{"message": "successfully updated password"}))
+	if _, ok := claims.Custom["sid"]; ok {
+		clearSessionCookies(w)
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(map[string]any{"message": "successfully logged out on all devices"}))
 }
 
-func (app *Configs) UserRoleHandler(w http.ResponseWriter, r *http.Request) {
-	var requestBody struct {
+// LogoutUserHandler takes an email address and logs the related user out on all devices
+func (app *Configs) LogoutUserHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
 		Email string `json:"email"`
 		validator.Validator
 	}
 
-	if err := helpers.ReadJSON(w, r, &requestBody); err != nil {
+	if err := helpers.ReadJSON(w, r, &body); err != nil {
 		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("unable to parse json body"))
 		return
 	}
 
-	requestBody.CheckRequired(requestBody.Email, "email")
-	requestBody.CheckValue(validator.IsEmail(requestBody.Email), "email", "valid email required")
-
-	if !requestBody.Valid() {
-		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(requestBody.Error()))
+	body.CheckRequired(body.Email, "email")
+	body.CheckValue(validator.IsEmail(body.Email), "email", "valid email required")
+	if !body.Valid() {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(body.Error()))
 		return
 	}
 
-	user, err := app.DB.GetUser(r.Context(), requestBody.Email)
+	user, err := app.DB.GetUser(r.Context(), body.Email)
 	if errors.Is(err, sql.ErrNoRows) {
-		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("user does not exist"))
+		helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(map[string]any{"message": "user not found"}))
 		return
 	}
 
@@ -546,11 +641,1161 @@ func (app *Configs) UserRoleHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var respBody struct {
-		Role string `json:"role"`
+	if _, err := app.DB.IncrementTokenVersion(r.Context(), user.UserID); err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
 	}
 
-	respBody.Role = user.Role
+	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(nil))
+}
 
-	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(respBody))
+// UnlockUserHandler takes an email address and forgets its failed sign in attempts, which lifts a sign in lockout
+func (app *Configs) UnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+		validator.Validator
+	}
+
+	if err := helpers.ReadJSON(w, r, &body); err != nil {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("unable to parse json body"))
+		return
+	}
+
+	body.CheckRequired(body.Email, "email")
+	body.CheckValue(validator.IsEmail(body.Email), "email", "valid email required")
+	if !body.Valid() {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(body.Error()))
+		return
+	}
+
+	if err := app.resetLoginFailures(r.Context(), body.Email); err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(map[string]any{"message": "successfully unlocked user"}))
+}
+
+// DeleteUserHandler takes an email address and deletes the related user and verification data
+func (app *Configs) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+		validator.Validator
+	}
+
+	if err := helpers.ReadJSON(w, r, &body); err != nil {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("unable to parse json body"))
+		return
+	}
+
+	body.CheckRequired(body.Email, "email")
+	body.CheckValue(validator.IsEmail(body.Email), "email", "valid email required")
+	if !body.Valid() {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(body.Error()))
+		return
+	}
+
+	recordsDeleted, err := app.DB.DeleteUser(r.Context(), body.Email)
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	if !recordsDeleted {
+		helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(map[string]any{"message": "user not found"}))
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(nil))
+}
+
+func (app *Configs) ResetPasswordRequestHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		Email string `json:"email"`
+		validator.Validator
+	}
+
+	if err := helpers.ReadJSON(w, r, &requestBody); err != nil {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("unable to parse json body"))
+		return
+	}
+
+	requestBody.CheckRequired(requestBody.Email, "email")
+	requestBody.CheckValue(validator.IsEmail(requestBody.Email), "email", "valid email required")
+	if !requestBody.Valid() {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(requestBody.Error()))
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), requestBody.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("user does not exist"))
+		return
+	}
+
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	if user.Status != models.UserStatusActive {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("user is not active"))
+		return
+	}
+
+	verificationCode, err := app.Verifier.GenerateVerificationCode()
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	verification := models.Verification{
+		Email:             requestBody.Email,
+		VerificationType:  models.VerificationTypeReset,
+		VerificationCode:  verificationCode,
+		ExpiresAt:         time.Now().Add(time.Hour * 24),
+		AttemptsRemaining: app.Verifier.MaxRetries(),
+	}
+
+	// the user can't be left waiting for a reset code that was never stored
+	user.Status = models.UserStatusVerifyResetPassword
+	err = app.DB.WithTx(r.Context(), func(repo storage.DBRepo) error {
+		if err := repo.UpdateUser(r.Context(), *user); err != nil {
+			return err
+		}
+
+		return repo.InsertOrUpdateVerification(r.Context(), verification)
+	})
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	var responseBody struct {
+		VerificationCode string `json:"verification_code"`
+	}
+
+	responseBody.VerificationCode = verificationCode
+
+	app.Metrics.PasswordResetRequested()
+	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(responseBody))
+}
+
+func (app *Configs) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		Email            string `json:"email"`
+		Password         string `json:"password"`
+		VerificationCode string `json:"verification_code"`
+		validator.Validator
+	}
+
+	if err := helpers.ReadJSON(w, r, &requestBody); err != nil {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("unable to parse json body"))
+		return
+	}
+
+	requestBody.CheckRequired(requestBody.Email, "email")
+	requestBody.CheckRequired(requestBody.Password, "password")
+	requestBody.CheckValue(validator.IsEmail(requestBody.Email), "email", "valid email required")
+	requestBody.CheckRequired(requestBody.VerificationCode, "verification_code")
+
+	if !requestBody.Valid() {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(requestBody.Error()))
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), requestBody.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("user does not exist"))
+		return
+	}
+
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	if user.Status != models.UserStatusVerifyResetPassword {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("password reset must first be requested"))
+		return
+	}
+
+	verification, err := app.DB.GetVerification(r.Context(), models.VerificationTypeReset, requestBody.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(fmt.Sprintf("no password reset verification data found for user %s", requestBody.Email)))
+		return
+	}
+
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	if verification.ExpiresAt.Before(time.Now()) || verification.AttemptsRemaining <= 0 {
+		err := app.DB.DeleteVerification(r.Context(), requestBody.Email)
+		if err != nil {
+			app.logger(r).Error(err.Error())
+		}
+
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("password reset verification code has expired"))
+		return
+	}
+
+	if requestBody.VerificationCode != verification.VerificationCode {
+		if verification.AttemptsRemaining >= 0 {
+			if err := app.DB.InsertOrUpdateVerification(r.Context(), *verification); err != nil {
+				helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+				return
+			}
+		}
+
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("invalid password reset verification code"))
+		return
+	}
+
+	hashedPasswordBytes, err := app.hashPassword(r.Context(), requestBody.Password)
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	user.Status = models.UserStatusActive
+	user.Password = string(hashedPasswordBytes)
+	err = app.DB.WithTx(r.Context(), func(repo storage.DBRepo) error {
+		if err := repo.UpdateUser(r.Context(), *user); err != nil {
+			return err
+		}
+
+		// tokens issued using the old password must not outlive it
+		if _, err := repo.IncrementTokenVersion(r.Context(), user.UserID); err != nil {
+			return err
+		}
+
+		return repo.DeleteVerification(r.Context(), user.Email)
+	})
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	app.Metrics.PasswordResetCompleted()
+	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(nil))
+}
+
+func (app *Configs) UpdatePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		Email       string `json:"email"`
+		OldPassword string `json:"old_password"`
+		NewPassword string `json:"new_password"`
+		validator.Validator
+	}
+
+	if err := helpers.ReadJSON(w, r, &requestBody); err != nil {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("unable to parse json body"))
+		return
+	}
+
+	requestBody.CheckRequired(requestBody.Email, "email")
+	requestBody.CheckRequired(requestBody.OldPassword, "old password")
+	requestBody.CheckRequired(requestBody.NewPassword, "new password")
+	requestBody.CheckValue(validator.IsEmail(requestBody.Email), "email", "valid email required")
+
+	if !requestBody.Valid() {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(requestBody.Error()))
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), requestBody.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("user does not exist"))
+		return
+	}
+
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	if user.Status != models.UserStatusActive {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("user not active"))
+		return
+	}
+	err = app.comparePassword(r.Context(), []byte(user.Password), []byte(requestBody.OldPassword))
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("old password is invalid"))
+		return
+	}
+
+	hashedPasswordBytes, err := app.hashPassword(r.Context(), requestBody.NewPassword)
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	user.Password = string(hashedPasswordBytes)
+	err = app.DB.WithTx(r.Context(), func(repo storage.DBRepo) error {
+		if err := repo.UpdateUser(r.Context(), *user); err != nil {
+			return err
+		}
+
+		// tokens issued using the old password must not outlive it
+		_, err := repo.IncrementTokenVersion(r.Context(), user.UserID)
+		return err
+	})
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(map[string]any{"message": "successfully updated password"}))
+}
+
+func (app *Configs) UserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		Email string `json:"email"`
+		validator.Validator
+	}
+
+	if err := helpers.ReadJSON(w, r, &requestBody); err != nil {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("unable to parse json body"))
+		return
+	}
+
+	requestBody.CheckRequired(requestBody.Email, "email")
+	requestBody.CheckValue(validator.IsEmail(requestBody.Email), "email", "valid email required")
+
+	if !requestBody.Valid() {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(requestBody.Error()))
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), requestBody.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("user does not exist"))
+		return
+	}
+
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	var respBody struct {
+		Role string `json:"role"`
+	}
+
+	respBody.Role = user.Role
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(respBody))
+}
+
+// UpdateUserRoleHandler changes the role of a user. Tokens issued with the old role are revoked
+func (app *Configs) UpdateUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+		validator.Validator
+	}
+
+	if err := helpers.ReadJSON(w, r, &requestBody); err != nil {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("unable to parse json body"))
+		return
+	}
+
+	requestBody.CheckRequired(requestBody.Email, "email")
+	requestBody.CheckRequired(requestBody.Role, "role")
+	requestBody.CheckValue(validator.IsEmail(requestBody.Email), "email", "valid email required")
+	requestBody.CheckValue(requestBody.Role == models.RoleUser || requestBody.Role == models.RoleAdmin, "role", fmt.Sprintf("must be %s or %s", models.RoleUser, models.RoleAdmin))
+
+	if !requestBody.Valid() {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(requestBody.Error()))
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), requestBody.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("user does not exist"))
+		return
+	}
+
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	user.Role = requestBody.Role
+	err = app.DB.WithTx(r.Context(), func(repo storage.DBRepo) error {
+		if err := repo.UpdateUser(r.Context(), *user); err != nil {
+			return err
+		}
+
+		_, err := repo.IncrementTokenVersion(r.Context(), user.UserID)
+		return err
+	})
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(map[string]any{"message": "successfully updated role"}))
+}
+
+// IntrospectHandler reports whether a token is active based on the OAuth 2.0 token introspection specification
+// (RFC 7662). Callers authenticate using client credentials instead of a user token
+func (app *Configs) IntrospectHandler(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.ReadForm(w, r); err != nil {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_request", "unable to parse form body"))
+		return
+	}
+
+	if !app.authenticateIntrospectionClient(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="auth_api"`)
+		helpers.WriteJSON(w, http.StatusUnauthorized, helpers.OAuthErrorResponse("invalid_client", "client authentication failed"))
+		return
+	}
+
+	tokenString := r.PostForm.Get("token")
+	if tokenString == "" {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_request", "token is required"))
+		return
+	}
+
+	var responseBody struct {
+		Active    bool   `json:"active"`
+		Subject   string `json:"sub,omitempty"`
+		ExpiresAt int64  `json:"exp,omitempty"`
+		TokenID   string `json:"jti,omitempty"`
+		TokenType string `json:"token_type,omitempty"`
+		Role      string `json:"role,omitempty"`
+		ClientID  string `json:"client_id,omitempty"`
+		Scope     string `json:"scope,omitempty"`
+	}
+
+	// any token that can not be validated is reported as inactive without giving a reason
+	claims, err := app.TokenUtils.ValidateToken(tokenString)
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusOK, responseBody)
+		return
+	}
+
+	if claims.ID != "" {
+		revoked, err := app.DB.IsTokenRevoked(r.Context(), claims.ID)
+		if err != nil {
+			helpers.WriteJSON(w, http.StatusInternalServerError, helpers.OAuthErrorResponse("server_error", ""))
+			return
+		}
+
+		if revoked {
+			helpers.WriteJSON(w, http.StatusOK, responseBody)
+			return
+		}
+	}
+
+	clientID, _ := claims.Custom["client_id"].(string)
+	if clientID != "" {
+		// tokens issued to clients are only active while the client is registered
+		_, err := app.DB.GetOAuthClient(r.Context(), clientID)
+		if errors.Is(err, sql.ErrNoRows) {
+			helpers.WriteJSON(w, http.StatusOK, responseBody)
+			return
+		}
+
+		if err != nil {
+			helpers.WriteJSON(w, http.StatusInternalServerError, helpers.OAuthErrorResponse("server_error", ""))
+			return
+		}
+
+	}
+
+	if claims.IsUserToken() {
+		user, err := app.DB.GetUserByID(r.Context(), claims.Subject)
+		if errors.Is(err, sql.ErrNoRows) {
+			helpers.WriteJSON(w, http.StatusOK, responseBody)
+			return
+		}
+
+		if err != nil {
+			helpers.WriteJSON(w, http.StatusInternalServerError, helpers.OAuthErrorResponse("server_error", ""))
+			return
+		}
+
+		// tokens of users that are no longer active (e.g. while resetting their password) are inactive as well
+		if claims.TokenVersion < user.TokenVersion || user.Status != models.UserStatusActive {
+			helpers.WriteJSON(w, http.StatusOK, responseBody)
+			return
+		}
+
+		responseBody.Role = user.Role
+	}
+
+	responseBody.Active = true
+	responseBody.ClientID = clientID
+	responseBody.Scope = strings.Join(claims.Scopes, " ")
+	responseBody.Subject = claims.Subject
+	responseBody.TokenID = claims.ID
+	responseBody.TokenType = "Bearer"
+	if !claims.ExpiresAt.IsZero() {
+		responseBody.ExpiresAt = claims.ExpiresAt.Unix()
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, responseBody)
+}
+
+// OAuthTokenHandler is the OAuth 2.0 token endpoint (RFC 6749 section 3.2). The client_credentials,
+// authorization_code and token exchange (RFC 8693) grants are supported
+func (app *Configs) OAuthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.ReadForm(w, r); err != nil {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_request", "unable to parse form body"))
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "":
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_request", "grant_type is required"))
+	case grantTypeClientCredentials:
+		app.clientCredentialsGrant(w, r)
+	case grantTypeAuthorizationCode:
+		app.authorizationCodeGrant(w, r)
+	case grantTypeTokenExchange:
+		app.tokenExchangeGrant(w, r)
+	default:
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("unsupported_grant_type", ""))
+	}
+}
+
+// clientCredentialsGrant issues an access token to a registered client (RFC 6749 section 4.4). If no scope is
+// requested, all the scopes of the client are granted
+func (app *Configs) clientCredentialsGrant(w http.ResponseWriter, r *http.Request) {
+	client, err := app.authenticateOAuthClient(r)
+	if errors.Is(err, errorInvalidClient) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="auth_api"`)
+		helpers.WriteJSON(w, http.StatusUnauthorized, helpers.OAuthErrorResponse("invalid_client", err.Error()))
+		return
+	}
+
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.OAuthErrorResponse("server_error", ""))
+		return
+	}
+
+	if client.IsPublic() {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("unauthorized_client", "public clients can't use the client_credentials grant"))
+		return
+	}
+
+	scopes := strings.Fields(r.PostForm.Get("scope"))
+	if len(scopes) == 0 {
+		scopes = client.ScopeList()
+	}
+
+	if !client.AllowsScopes(scopes) {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_scope", "scope not allowed for client"))
+		return
+	}
+
+	claims := verify.Claims{
+		Subject: client.ClientID,
+		Scopes:  scopes,
+		Custom:  map[string]any{"client_id": client.ClientID},
+	}
+
+	tokenString, err := app.TokenUtils.GenerateToken(claims, app.AccessTokenTTL)
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.OAuthErrorResponse("server_error", "auth token generation failed"))
+		return
+	}
+
+	app.writeOAuthTokenResponse(w, tokenString, "", scopes)
+}
+
+// authorizationCodeGrant exchanges an authorization code for an access token (RFC 6749 section 4.1.3). The code
+// verifier must match the code challenge of the authorization request (RFC 7636 section 4.5)
+func (app *Configs) authorizationCodeGrant(w http.ResponseWriter, r *http.Request) {
+	client, err := app.authenticateOAuthClient(r)
+	if errors.Is(err, errorInvalidClient) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="auth_api"`)
+		helpers.WriteJSON(w, http.StatusUnauthorized, helpers.OAuthErrorResponse("invalid_client", err.Error()))
+		return
+	}
+
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.OAuthErrorResponse("server_error", ""))
+		return
+	}
+
+	codeString := r.PostForm.Get("code")
+	codeVerifier := r.PostForm.Get("code_verifier")
+	if codeString == "" || codeVerifier == "" {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_request", "code and code_verifier are required"))
+		return
+	}
+
+	code, err := app.DB.ConsumeAuthorizationCode(r.Context(), verify.HashOpaqueToken(codeString))
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_grant", "invalid authorization code"))
+		return
+	}
+
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.OAuthErrorResponse("server_error", ""))
+		return
+	}
+
+	if code.ExpiresAt.Before(time.Now()) {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_grant", "authorization code has expired"))
+		return
+	}
+
+	if code.ClientID != client.ClientID || code.RedirectURI != r.PostForm.Get("redirect_uri") {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_grant", "invalid authorization code"))
+		return
+	}
+
+	if !verify.VerifyCodeChallenge(codeVerifier, code.CodeChallenge) {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_grant", "invalid code_verifier"))
+		return
+	}
+
+	user, err := app.DB.GetUserByID(r.Context(), code.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_grant", "invalid authorization code"))
+		return
+	}
+
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.OAuthErrorResponse("server_error", ""))
+		return
+	}
+
+	if user.Status != models.UserStatusActive {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_grant", "user not active"))
+		return
+	}
+
+	// the token is limited to the scopes the user approved, instead of the scopes of password sign ins. The role is
+	// left out so tokens issued to clients never grant admin access rights
+	scopes := strings.Fields(code.Scope)
+	claims := verify.Claims{
+		Subject:      user.UserID,
+		Email:        user.Email,
+		TokenVersion: user.TokenVersion,
+		Scopes:       scopes,
+		Custom:       map[string]any{"client_id": client.ClientID},
+	}
+
+	tokenString, err := app.TokenUtils.GenerateToken(claims, app.AccessTokenTTL)
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.OAuthErrorResponse("server_error", "auth token generation failed"))
+		return
+	}
+
+	var idToken string
+	if slices.Contains(scopes, scopeOpenID) {
+		idToken, err = app.newIDToken(user, code)
+		if err != nil {
+			helpers.WriteJSON(w, http.StatusInternalServerError, helpers.OAuthErrorResponse("server_error", "id token generation failed"))
+			return
+		}
+	}
+
+	app.writeOAuthTokenResponse(w, tokenString, idToken, scopes)
+}
+
+// tokenExchangeGrant lets an admin impersonate a user (RFC 8693). The admin sends their access token as the
+// subject_token and the user id as the requested_subject. The issued token is short lived, names the admin in its act
+// claim and every impersonation is audited
+func (app *Configs) tokenExchangeGrant(w http.ResponseWriter, r *http.Request) {
+	subjectToken := r.PostForm.Get("subject_token")
+	requestedSubject := r.PostForm.Get("requested_subject")
+	if subjectToken == "" || r.PostForm.Get("subject_token_type") == "" || requestedSubject == "" {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_request", "subject_token, subject_token_type and requested_subject are required"))
+		return
+	}
+
+	if r.PostForm.Get("subject_token_type") != tokenTypeAccessToken {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_request", "unsupported subject_token_type"))
+		return
+	}
+
+	if requestedTokenType := r.PostForm.Get("requested_token_type"); requestedTokenType != "" && requestedTokenType != tokenTypeAccessToken {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_request", "unsupported requested_token_type"))
+		return
+	}
+
+	// impersonated tokens can't be exchanged, even if the impersonated user is an admin. Tokens without the admin scope
+	// (e.g. issued to an OAuth client) can't be exchanged either
+	adminClaims, err := app.TokenUtils.ValidateToken(subjectToken)
+	if err != nil || adminClaims.IsImpersonated() || !adminClaims.HasScopes(models.ScopeAdmin) {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_request", "admin access rights required"))
+		return
+	}
+
+	// logged out tokens, and tokens issued before a logout on all devices or a password change, can't be exchanged
+	err = middleware.CheckTokenRevoked(r.Context(), app.DB, adminClaims)
+	if errors.Is(err, middleware.ErrTokenRevoked) {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_request", "subject_token has been revoked"))
+		return
+	}
+
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.OAuthErrorResponse("server_error", ""))
+		return
+	}
+
+	isAdmin, err := middleware.IsAdmin(r.Context(), app.DB, adminClaims)
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.OAuthErrorResponse("server_error", ""))
+		return
+	}
+
+	if !isAdmin {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_request", "admin access rights required"))
+		return
+	}
+
+	if uuid.Validate(requestedSubject) != nil {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_request", "requested_subject must be a user id"))
+		return
+	}
+
+	user, err := app.DB.GetUserByID(r.Context(), requestedSubject)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_request", "user does not exist"))
+		return
+	}
+
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.OAuthErrorResponse("server_error", ""))
+		return
+	}
+
+	if user.Status != models.UserStatusActive {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.OAuthErrorResponse("invalid_request", "user not active"))
+		return
+	}
+
+	// impersonated tokens never carry the admin scope, even when the user is an admin
+	claims := userClaims(user)
+	claims.ID = uuid.New().String()
+	claims.Scopes = models.DefaultUserScopes
+	claims.Custom = map[string]any{"act": map[string]any{"sub": adminClaims.Subject}}
+
+	tokenString, err := app.TokenUtils.GenerateToken(claims, app.ImpersonationTokenTTL)
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.OAuthErrorResponse("server_error", "auth token generation failed"))
+		return
+	}
+
+	// the token is only returned once the impersonation has been audited
+	impersonation := &models.Impersonation{
+		ImpersonationID: uuid.New().String(),
+		Actor:           adminClaims.Subject,
+		UserID:          user.UserID,
+		TokenID:         claims.ID,
+		ExpiresAt:       time.Now().Add(app.ImpersonationTokenTTL),
+	}
+
+	if err := app.DB.CreateImpersonation(r.Context(), impersonation); err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.OAuthErrorResponse("server_error", ""))
+		return
+	}
+
+	app.logger(r).Info("user impersonated", "actor", impersonation.Actor, "user_id", impersonation.UserID, "token_id", impersonation.TokenID)
+
+	var responseBody struct {
+		AccessToken     string `json:"access_token"`
+		IssuedTokenType string `json:"issued_token_type"`
+		TokenType       string `json:"token_type"`
+		ExpiresIn       int64  `json:"expires_in"`
+	}
+
+	responseBody.AccessToken = tokenString
+	responseBody.IssuedTokenType = tokenTypeAccessToken
+	responseBody.TokenType = "Bearer"
+	responseBody.ExpiresIn = int64(app.ImpersonationTokenTTL.Seconds())
+
+	helpers.WriteJSON(w, http.StatusOK, responseBody)
+}
+
+// AuthorizeHandler is the OAuth 2.0 authorization endpoint (RFC 6749 section 3.1). It renders a page where the user
+// signs in and approves the authorization request of the client
+func (app *Configs) AuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		app.renderAuthorizePage(w, r, http.StatusBadRequest, nil, "", errors.New("invalid authorization request"))
+		return
+	}
+
+	request, err := app.parseAuthorizationRequest(r)
+	if !app.handleAuthorizationRequestError(w, r, request, err) {
+		return
+	}
+
+	app.renderAuthorizePage(w, r, http.StatusOK, request, "", nil)
+}
+
+// AuthorizeDecisionHandler handles the login and consent form of the authorization endpoint. If the user signs in
+// and approves the request, the user is redirected to the client with a single use authorization code
+func (app *Configs) AuthorizeDecisionHandler(w http.ResponseWriter, r *http.Request) {
+	if err := helpers.ReadForm(w, r); err != nil {
+		app.renderAuthorizePage(w, r, http.StatusBadRequest, nil, "", errors.New("invalid authorization request"))
+		return
+	}
+
+	request, err := app.parseAuthorizationRequest(r)
+	if !app.handleAuthorizationRequestError(w, r, request, err) {
+		return
+	}
+
+	if r.PostForm.Get("action") != "approve" {
+		redirectToClient(w, r, request, url.Values{"error": {"access_denied"}})
+		return
+	}
+
+	email := r.PostForm.Get("email")
+	clientIP := app.TrustedProxies.ClientIP(r)
+	retryAfter, err := app.loginRetryAfter(r.Context(), email, clientIP)
+	if err != nil {
+		app.renderAuthorizePage(w, r, http.StatusInternalServerError, request, email, errors.New("unable to sign in"))
+		return
+	}
+
+	if retryAfter > 0 {
+		app.Metrics.LoginFailed(metrics.LoginFailureLocked)
+		setRetryAfter(w, retryAfter)
+		app.renderAuthorizePage(w, r, http.StatusTooManyRequests, request, email, errorLoginLocked)
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		app.renderAuthorizePage(w, r, http.StatusInternalServerError, request, email, errors.New("unable to sign in"))
+		return
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		app.compareUnknownUserPassword(r.Context(), r.PostForm.Get("password"))
+	}
+
+	if err != nil || app.comparePassword(r.Context(), []byte(user.Password), []byte(r.PostForm.Get("password"))) != nil {
+		if err := app.recordLoginFailure(r.Context(), email, clientIP); err != nil {
+			app.renderAuthorizePage(w, r, http.StatusInternalServerError, request, email, errors.New("unable to sign in"))
+			return
+		}
+
+		app.Metrics.LoginFailed(metrics.LoginFailureInvalidCredentials)
+		app.renderAuthorizePage(w, r, http.StatusUnauthorized, request, email, errorAuthorizationFailed)
+		return
+	}
+
+	if err := app.resetLoginFailures(r.Context(), email); err != nil {
+		app.renderAuthorizePage(w, r, http.StatusInternalServerError, request, email, errors.New("unable to sign in"))
+		return
+	}
+
+	if user.Status != models.UserStatusActive {
+		app.Metrics.LoginFailed(metrics.LoginFailureNotActive)
+		app.renderAuthorizePage(w, r, http.StatusUnauthorized, request, email, errors.New("user not active"))
+		return
+	}
+
+	codeString, err := verify.GenerateOpaqueToken()
+	if err != nil {
+		redirectToClient(w, r, request, url.Values{"error": {"server_error"}})
+		return
+	}
+
+	code := &models.AuthorizationCode{
+		CodeHash:      verify.HashOpaqueToken(codeString),
+		ClientID:      request.Client.ClientID,
+		UserID:        user.UserID,
+		RedirectURI:   request.RedirectURI,
+		Scope:         strings.Join(request.Scopes, " "),
+		CodeChallenge: request.CodeChallenge,
+		Nonce:         request.Nonce,
+		AuthTime:      time.Now(),
+		ExpiresAt:     time.Now().Add(app.AuthorizationCodeTTL),
+	}
+
+	if err := app.DB.CreateAuthorizationCode(r.Context(), code); err != nil {
+		redirectToClient(w, r, request, url.Values{"error": {"server_error"}})
+		return
+	}
+
+	app.Metrics.LoginSucceeded()
+	redirectToClient(w, r, request, url.Values{"code": {codeString}})
+}
+
+// handleAuthorizationRequestError responds to an invalid authorization request. It returns true if the request is
+// valid
+func (app *Configs) handleAuthorizationRequestError(w http.ResponseWriter, r *http.Request, request *authorizationRequest, err error) bool {
+	var authErr authorizationError
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errorUnknownClient), errors.Is(err, errorInvalidRedirectURI):
+		app.renderAuthorizePage(w, r, http.StatusBadRequest, nil, "", err)
+	case errors.As(err, &authErr):
+		redirectToClient(w, r, request, url.Values{"error": {authErr.Code}, "error_description": {authErr.Description}})
+	default:
+		app.renderAuthorizePage(w, r, http.StatusInternalServerError, nil, "", errors.New("unable to process authorization request"))
+	}
+
+	return false
+}
+
+// CreateOAuthClientHandler registers an OAuth client. The client secret is only returned once
+func (app *Configs) CreateOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		Name         string   `json:"name"`
+		Scopes       []string `json:"scopes"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Public       bool     `json:"public"`
+		validator.Validator
+	}
+
+	if err := helpers.ReadJSON(w, r, &requestBody); err != nil {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("unable to parse json body"))
+		return
+	}
+
+	requestBody.CheckRequired(requestBody.Name, "name")
+	for _, scope := range requestBody.Scopes {
+		requestBody.CheckValue(validator.IsScope(scope), "scopes", "valid scopes required")
+		requestBody.CheckValue(scope != models.ScopeAdmin, "scopes", "the admin scope can't be granted to clients")
+	}
+	for _, redirectURI := range requestBody.RedirectURIs {
+		requestBody.CheckValue(validator.IsRedirectURI(redirectURI), "redirect_uris", "valid absolute uris without fragments required")
+	}
+
+	requestBody.CheckValue(!requestBody.Public || len(requestBody.RedirectURIs) > 0, "redirect_uris", "required for public clients")
+
+	if !requestBody.Valid() {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(requestBody.Error()))
+		return
+	}
+
+	client := &models.OAuthClient{
+		ClientID:     uuid.New().String(),
+		Name:         requestBody.Name,
+		Scopes:       strings.Join(requestBody.Scopes, " "),
+		RedirectURIs: strings.Join(requestBody.RedirectURIs, " "),
+	}
+
+	// public clients (e.g. SPAs and mobile apps) can't keep a secret
+	var clientSecret string
+	if !requestBody.Public {
+		var err error
+		clientSecret, err = verify.GenerateOpaqueToken()
+		if err != nil {
+			helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+			return
+		}
+
+		client.ClientSecretHash = verify.HashOpaqueToken(clientSecret)
+	}
+
+	if err := app.DB.CreateOAuthClient(r.Context(), client); err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	var responseBody struct {
+		ClientID     string   `json:"client_id"`
+		ClientSecret string   `json:"client_secret,omitempty"`
+		Name         string   `json:"name"`
+		Scopes       []string `json:"scopes"`
+		RedirectURIs []string `json:"redirect_uris"`
+	}
+
+	responseBody.ClientID = client.ClientID
+	responseBody.ClientSecret = clientSecret
+	responseBody.Name = client.Name
+	responseBody.Scopes = client.ScopeList()
+	responseBody.RedirectURIs = client.RedirectURIList()
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(responseBody))
+}
+
+// OpenIDConfigurationHandler publishes the OpenID Connect discovery document (OpenID Connect Discovery section 3).
+// All endpoints are relative to the token issuer
+func (app *Configs) OpenIDConfigurationHandler(w http.ResponseWriter, r *http.Request) {
+	signingAlgorithm, ok := app.openIDSigningAlgorithm()
+	if !ok {
+		helpers.WriteJSON(w, http.StatusNotFound, helpers.ErrorResponse(errorOpenIDUnavailable.Error()))
+		return
+	}
+
+	issuer := strings.TrimSuffix(app.TokenIssuer, "/")
+
+	var responseBody struct {
+		Issuer                            string   `json:"issuer"`
+		AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+		TokenEndpoint                     string   `json:"token_endpoint"`
+		UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+		JWKSURI                           string   `json:"jwks_uri"`
+		IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+		ScopesSupported                   []string `json:"scopes_supported"`
+		ResponseTypesSupported            []string `json:"response_types_supported"`
+		GrantTypesSupported               []string `json:"grant_types_supported"`
+		SubjectTypesSupported             []string `json:"subject_types_supported"`
+		IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+		TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+		CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+		ClaimsSupported                   []string `json:"claims_supported"`
+	}
+
+	responseBody.Issuer = app.TokenIssuer
+	responseBody.AuthorizationEndpoint = issuer + "/oauth/authorize"
+	responseBody.TokenEndpoint = issuer + "/oauth/token"
+	responseBody.UserInfoEndpoint = issuer + "/userinfo"
+	responseBody.JWKSURI = issuer + "/.well-known/jwks.json"
+	responseBody.IntrospectionEndpoint = issuer + "/oauth/introspect"
+	responseBody.ScopesSupported = []string{scopeOpenID, scopeProfile, scopeEmail}
+	responseBody.ResponseTypesSupported = []string{"code"}
+	responseBody.GrantTypesSupported = []string{grantTypeAuthorizationCode, grantTypeClientCredentials}
+	responseBody.SubjectTypesSupported = []string{"public"}
+	responseBody.IDTokenSigningAlgValuesSupported = []string{signingAlgorithm}
+	responseBody.TokenEndpointAuthMethodsSupported = []string{"client_secret_basic", "client_secret_post", "none"}
+	responseBody.CodeChallengeMethodsSupported = []string{verify.CodeChallengeMethodS256}
+	responseBody.ClaimsSupported = []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "email", "email_verified", "updated_at"}
+
+	helpers.WriteJSON(w, http.StatusOK, responseBody)
+}
+
+// UserInfoHandler returns the claims of the signed in user (OpenID Connect Core section 5.3). The access token must
+// have been issued with the openid scope
+func (app *Configs) UserInfoHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.ClaimsFromContext(r.Context())
+	if !ok {
+		helpers.WriteJSON(w, http.StatusUnauthorized, helpers.OAuthErrorResponse("invalid_token", ""))
+		return
+	}
+
+	if !claims.HasScopes(scopeOpenID) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		helpers.WriteJSON(w, http.StatusForbidden, helpers.OAuthErrorResponse("insufficient_scope", "the openid scope is required"))
+		return
+	}
+
+	if uuid.Validate(claims.Subject) != nil {
+		helpers.WriteJSON(w, http.StatusUnauthorized, helpers.OAuthErrorResponse("invalid_token", ""))
+		return
+	}
+
+	user, err := app.DB.GetUserByID(r.Context(), claims.Subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.WriteJSON(w, http.StatusUnauthorized, helpers.OAuthErrorResponse("invalid_token", ""))
+		return
+	}
+
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.OAuthErrorResponse("server_error", ""))
+		return
+	}
+
+	var responseBody struct {
+		Subject       string `json:"sub"`
+		Email         string `json:"email,omitempty"`
+		EmailVerified *bool  `json:"email_verified,omitempty"`
+		UpdatedAt     int64  `json:"updated_at,omitempty"`
+	}
+
+	responseBody.Subject = user.UserID
+
+	if claims.HasScopes(scopeEmail) {
+		emailVerified := user.IsVerified()
+		responseBody.Email = user.Email
+		responseBody.EmailVerified = &emailVerified
+	}
+
+	if claims.HasScopes(scopeProfile) {
+		responseBody.UpdatedAt = user.UpdatedAt.Unix()
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, responseBody)
+}
+
+// JWKSHandler publishes the public keys that other services can use to validate tokens issued by this api
+func (app *Configs) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	helpers.WriteJSON(w, http.StatusOK, app.TokenUtils.JWKS())
+}
+
+// RotateSigningKeyHandler generates a new signing key that is used to sign all new tokens. Tokens signed with the
+// previous key stay valid until they expire
+func (app *Configs) RotateSigningKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if app.KeyEncryptor == nil {
+		helpers.WriteJSON(w, http.StatusNotFound, helpers.ErrorResponse(errorKeyEncryptionRequired.Error()))
+		return
+	}
+
+	var requestBody struct {
+		Algorithm string `json:"algorithm"`
+		validator.Validator
+	}
+
+	if err := helpers.ReadJSON(w, r, &requestBody); err != nil {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("unable to parse json body"))
+		return
+	}
+
+	if requestBody.Algorithm == "" {
+		activeKey, err := app.Keyring.ActiveKey()
+		if err != nil {
+			helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+			return
+		}
+
+		requestBody.Algorithm = activeKey.Algorithm
+	}
+
+	// e.g. a PASETO key can't be used to issue JWTs
+	requestBody.CheckValue(slices.Contains(app.TokenUtils.SigningMethods(), requestBody.Algorithm), "algorithm", "unsupported signing algorithm")
+	if !requestBody.Valid() {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse(requestBody.Error()))
+		return
+	}
+
+	key, err := verify.GenerateSigningKey(requestBody.Algorithm)
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	privateKey, err := key.EncodePrivateKey()
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	encryptedPrivateKey, err := app.KeyEncryptor.Encrypt(key.KeyID, privateKey)
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	storedKey := &models.SigningKey{
+		KeyID:               key.KeyID,
+		Algorithm:           key.Algorithm,
+		EncryptedPrivateKey: encryptedPrivateKey,
+	}
+
+	if err := app.DB.RotateSigningKey(r.Context(), storedKey, time.Now().Add(app.AccessTokenTTL)); err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	if err := app.loadSigningKeys(r.Context()); err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	var responseBody struct {
+		KeyID     string `json:"key_id"`
+		Algorithm string `json:"algorithm"`
+	}
+
+	responseBody.KeyID = key.KeyID
+	responseBody.Algorithm = key.Algorithm
+
+	helpers.WriteJSON(w, http.StatusOK, helpers.SuccessResponse(responseBody))
 }
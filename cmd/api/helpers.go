@@ -1 +1,615 @@
 package main
+
+import (
+	"auth_api/internal/helpers"
+	"auth_api/internal/metrics"
+	"auth_api/internal/middleware"
+	"auth_api/internal/models"
+	"auth_api/internal/tracing"
+	"auth_api/internal/verify"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var tracer = tracing.Tracer("api")
+
+var (
+	errorInvalidClient = errors.New("client authentication failed")
+	errorLoginLocked   = errors.New("too many failed sign in attempts, try again later")
+	// errorKeyEncryptionRequired is returned instead of storing signing keys in the DB in plaintext
+	errorKeyEncryptionRequired = errors.New("AUTH_SIGNING_KEY_ENCRYPTION_KEY is required to store signing keys in the db")
+)
+
+// newRefreshToken generates an opaque refresh token for a token family. The plain text token is returned to the
+// caller and only its hash is stored
+func (app *Configs) newRefreshToken(userID, familyID string) (string, *models.RefreshToken, error) {
+	tokenString, err := app.RefreshTokens.GenerateRefreshToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	refreshToken := &models.RefreshToken{
+		TokenID:   uuid.New().String(),
+		FamilyID:  familyID,
+		UserID:    userID,
+		TokenHash: verify.HashOpaqueToken(tokenString),
+		ExpiresAt: time.Now().Add(app.RefreshTokenTTL),
+	}
+
+	return tokenString, refreshToken, nil
+}
+
+// userClaims returns the claims of an access token issued to a user
+func userClaims(user *models.User) verify.Claims {
+	return verify.Claims{
+		Subject:      user.UserID,
+		Email:        user.Email,
+		Role:         user.Role,
+		TokenVersion: user.TokenVersion,
+		Scopes:       user.Scopes(),
+	}
+}
+
+// loadSigningKeys replaces the keys in the keyring with the keys configured using environment variables and the
+// keys stored in the DB. The newest stored key that has not been scheduled for retirement is the active key
+func (app *Configs) loadSigningKeys(ctx context.Context) error {
+	storedKeys, err := app.DB.GetSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(storedKeys) > 0 && app.KeyEncryptor == nil {
+		return errorKeyEncryptionRequired
+	}
+
+	keys := make([]verify.SigningKey, 0, len(app.EnvSigningKeys)+len(storedKeys))
+	keys = append(keys, app.EnvSigningKeys...)
+	activeKeyID := app.EnvSigningKeys[0].KeyID
+
+	for i, storedKey := range storedKeys {
+		privateKey, err := app.KeyEncryptor.Decrypt(storedKey.KeyID, storedKey.EncryptedPrivateKey)
+		if err != nil {
+			return fmt.Errorf("unable to load signing key %s: %w", storedKey.KeyID, err)
+		}
+
+		key, err := verify.ParseSigningKey(storedKey.KeyID, storedKey.Algorithm, privateKey)
+		if err != nil {
+			return fmt.Errorf("unable to load signing key %s: %w", storedKey.KeyID, err)
+		}
+
+		key.CreatedAt = storedKey.CreatedAt
+		key.RetiresAt = storedKey.RetiresAt
+
+		if i == 0 {
+			// the first rotation replaced the configured key. Tokens signed with it are valid until they expire
+			retiresAt := storedKey.CreatedAt.Add(app.AccessTokenTTL)
+			keys[0].RetiresAt = &retiresAt
+		}
+
+		if storedKey.RetiresAt == nil {
+			activeKeyID = key.KeyID
+		}
+
+		keys = append(keys, key)
+	}
+
+	app.Keyring.Replace(keys, activeKeyID)
+	return nil
+}
+
+// bootstrapAdmin creates the first admin user, so admins don't have to be added to the DB by hand. An active user
+// with the email is promoted to admin instead. Nothing is done once an admin exists
+func (app *Configs) bootstrapAdmin(ctx context.Context, email, password string) error {
+	if email == "" {
+		return nil
+	}
+
+	adminExists, err := app.DB.RoleExists(ctx, models.RoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	if adminExists {
+		return nil
+	}
+
+	user, err := app.DB.GetUser(ctx, email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if err == nil {
+		if user.Status != models.UserStatusActive {
+			return fmt.Errorf("unable to promote %s to admin: user not active", email)
+		}
+
+		user.Role = models.RoleAdmin
+		if err := app.DB.UpdateUser(ctx, *user); err != nil {
+			return err
+		}
+
+		app.Logger.Info("promoted user to admin", "email", email)
+		return nil
+	}
+
+	if password == "" {
+		return errors.New("AUTH_BOOTSTRAP_ADMIN_PASSWORD environment variable requires a value when the admin user does not exist")
+	}
+
+	hashedPasswordBytes, err := app.hashPassword(ctx, password)
+	if err != nil {
+		return err
+	}
+
+	user = &models.User{
+		UserID:   uuid.New().String(),
+		Email:    email,
+		Password: string(hashedPasswordBytes),
+		Status:   models.UserStatusActive,
+		Role:     models.RoleAdmin,
+	}
+
+	if err := app.DB.CreateUser(ctx, user); err != nil {
+		return err
+	}
+
+	app.Logger.Info("created admin user", "email", email)
+	return nil
+}
+
+type loginLockoutKey struct {
+	key     string
+	lockout verify.LoginLockout
+}
+
+// loginLockoutKeys returns the keys failed sign in attempts are counted under. Attempts are counted per email, whether
+// or not the account exists, and per client IP
+func (app *Configs) loginLockoutKeys(email, clientIP string) []loginLockoutKey {
+	return []loginLockoutKey{
+		{key: "email:" + strings.ToLower(email), lockout: app.LoginLockout},
+		{key: "ip:" + clientIP, lockout: app.IPLoginLockout},
+	}
+}
+
+// loginRetryAfter returns how long the caller has to wait before trying to sign in again. Zero means signing in is
+// allowed
+func (app *Configs) loginRetryAfter(ctx context.Context, email, clientIP string) (time.Duration, error) {
+	var retryAfter time.Duration
+	for _, lockoutKey := range app.loginLockoutKeys(email, clientIP) {
+		attempt, err := app.DB.GetLoginAttempt(ctx, lockoutKey.key)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		retryAfter = max(retryAfter, lockoutKey.lockout.RetryAfter(attempt, time.Now()))
+	}
+
+	return retryAfter, nil
+}
+
+// recordLoginFailure counts a failed sign in attempt for the email and the client IP
+func (app *Configs) recordLoginFailure(ctx context.Context, email, clientIP string) error {
+	for _, lockoutKey := range app.loginLockoutKeys(email, clientIP) {
+		if _, err := app.DB.RecordLoginFailure(ctx, lockoutKey.key, lockoutKey.lockout.MaxFailures, lockoutKey.lockout.LockoutDuration); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resetLoginFailures forgets the failed sign in attempts of an email, e.g. after the user signed in. The failed
+// attempts of client IPs are kept
+func (app *Configs) resetLoginFailures(ctx context.Context, email string) error {
+	return app.DB.DeleteLoginAttempt(ctx, "email:"+strings.ToLower(email))
+}
+
+// writeLoginFailed counts a failed sign in attempt and responds without revealing whether the account exists
+func (app *Configs) writeLoginFailed(w http.ResponseWriter, r *http.Request, email, clientIP string) {
+	if err := app.recordLoginFailure(r.Context(), email, clientIP); err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse(err.Error()))
+		return
+	}
+
+	app.Metrics.LoginFailed(metrics.LoginFailureInvalidCredentials)
+	helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("invalid email or password"))
+}
+
+// writeLoginLocked responds to a sign in attempt that was refused because of earlier failed attempts. The response is
+// the same for unknown emails
+func (app *Configs) writeLoginLocked(w http.ResponseWriter, retryAfter time.Duration) {
+	app.Metrics.LoginFailed(metrics.LoginFailureLocked)
+	setRetryAfter(w, retryAfter)
+	helpers.WriteJSON(w, http.StatusTooManyRequests, helpers.ErrorResponse(errorLoginLocked.Error()))
+}
+
+// clientCredentials returns the credentials of an OAuth client. The credentials can be sent using HTTP Basic
+// authentication or as client_id and client_secret form parameters
+func clientCredentials(r *http.Request) (string, string) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	return clientID, clientSecret
+}
+
+// authenticateIntrospectionClient checks the client credentials of a token introspection request
+func (app *Configs) authenticateIntrospectionClient(r *http.Request) bool {
+	if app.IntrospectionClientID == "" || app.IntrospectionClientSecret == "" {
+		return false
+	}
+
+	clientID, clientSecret := clientCredentials(r)
+
+	validID := subtle.ConstantTimeCompare([]byte(clientID), []byte(app.IntrospectionClientID)) == 1
+	validSecret := subtle.ConstantTimeCompare([]byte(clientSecret), []byte(app.IntrospectionClientSecret)) == 1
+
+	return validID && validSecret
+}
+
+// authenticateOAuthClient returns the registered client whose credentials were sent with the request. Public
+// clients only send their client_id. errorInvalidClient is returned if the client does not exist or the secret does
+// not match
+func (app *Configs) authenticateOAuthClient(r *http.Request) (*models.OAuthClient, error) {
+	clientID, clientSecret := clientCredentials(r)
+	if uuid.Validate(clientID) != nil {
+		return nil, errorInvalidClient
+	}
+
+	client, err := app.DB.GetOAuthClient(r.Context(), clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errorInvalidClient
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if client.IsPublic() {
+		if clientSecret != "" {
+			return nil, errorInvalidClient
+		}
+
+		return client, nil
+	}
+
+	if !verify.CompareOpaqueToken(client.ClientSecretHash, clientSecret) {
+		return nil, errorInvalidClient
+	}
+
+	return client, nil
+}
+
+// authorizationRequest contains the parameters of a request to the authorization endpoint (RFC 6749 section 4.1.1
+// and RFC 7636 section 4.3)
+type authorizationRequest struct {
+	Client              *models.OAuthClient
+	ClientID            string
+	ClientName          string
+	ResponseType        string
+	RedirectURI         string
+	Scope               string
+	Scopes              []string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+}
+
+// authorizationError is an error that is returned to the client by redirecting the user to its redirect uri
+type authorizationError struct {
+	Code        string
+	Description string
+}
+
+func (e authorizationError) Error() string {
+	return e.Description
+}
+
+var (
+	errorUnknownClient       = errors.New("unknown client")
+	errorInvalidRedirectURI  = errors.New("invalid redirect uri")
+	errorAuthorizationFailed = errors.New("invalid email or password")
+	errorOpenIDUnavailable   = errors.New("openid connect is not available")
+)
+
+// parseAuthorizationRequest validates the parameters of an authorization request. errorUnknownClient and
+// errorInvalidRedirectURI are shown to the user, because the user can't safely be redirected back to the client.
+// Other invalid parameters result in an authorizationError
+func (app *Configs) parseAuthorizationRequest(r *http.Request) (*authorizationRequest, error) {
+	request := &authorizationRequest{
+		ClientID:            r.Form.Get("client_id"),
+		ResponseType:        r.Form.Get("response_type"),
+		RedirectURI:         r.Form.Get("redirect_uri"),
+		Scope:               r.Form.Get("scope"),
+		State:               r.Form.Get("state"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+		Nonce:               r.Form.Get("nonce"),
+	}
+
+	if uuid.Validate(request.ClientID) != nil {
+		return nil, errorUnknownClient
+	}
+
+	client, err := app.DB.GetOAuthClient(r.Context(), request.ClientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errorUnknownClient
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	request.Client = client
+	request.ClientName = client.Name
+
+	// the redirect uri may only be omitted if the client registered a single redirect uri
+	redirectURIs := client.RedirectURIList()
+	if request.RedirectURI == "" && len(redirectURIs) != 1 {
+		return nil, errorInvalidRedirectURI
+	}
+
+	if request.RedirectURI != "" && !slices.Contains(redirectURIs, request.RedirectURI) {
+		return nil, errorInvalidRedirectURI
+	}
+
+	if request.ResponseType != "code" {
+		return request, authorizationError{Code: "unsupported_response_type", Description: "response_type must be code"}
+	}
+
+	if request.CodeChallenge == "" {
+		return request, authorizationError{Code: "invalid_request", Description: "code_challenge is required"}
+	}
+
+	if request.CodeChallengeMethod != verify.CodeChallengeMethodS256 {
+		return request, authorizationError{Code: "invalid_request", Description: "code_challenge_method must be S256"}
+	}
+
+	request.Scopes = strings.Fields(request.Scope)
+	if !client.AllowsScopes(request.Scopes) {
+		return request, authorizationError{Code: "invalid_scope", Description: "scope not allowed for client"}
+	}
+
+	if _, ok := app.openIDSigningAlgorithm(); slices.Contains(request.Scopes, scopeOpenID) && !ok {
+		return request, authorizationError{Code: "invalid_scope", Description: errorOpenIDUnavailable.Error()}
+	}
+
+	return request, nil
+}
+
+// redirectURI returns the uri the user is redirected to after the authorization request has been handled
+func (request authorizationRequest) redirectURI() string {
+	if request.RedirectURI != "" {
+		return request.RedirectURI
+	}
+
+	return request.Client.RedirectURIList()[0]
+}
+
+// redirectToClient redirects the user to the client with the params added to the query string of the redirect uri
+func redirectToClient(w http.ResponseWriter, r *http.Request, request *authorizationRequest, params url.Values) {
+	redirectURI, err := url.Parse(request.redirectURI())
+	if err != nil {
+		http.Error(w, "invalid redirect uri", http.StatusBadRequest)
+		return
+	}
+
+	if request.State != "" {
+		params.Set("state", request.State)
+	}
+
+	query := redirectURI.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+
+	redirectURI.RawQuery = query.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// renderAuthorizePage renders the login and consent page of the authorization endpoint
+func (app *Configs) renderAuthorizePage(w http.ResponseWriter, r *http.Request, status int, request *authorizationRequest, email string, pageError error) {
+	data := struct {
+		Request *authorizationRequest
+		Email   string
+		Error   string
+	}{
+		Request: request,
+		Email:   email,
+	}
+
+	if pageError != nil {
+		data.Error = pageError.Error()
+	}
+
+	// the form may only be submitted to the api, which redirects to the client after the user decided
+	formAction := "'self'"
+	if request != nil {
+		if redirectURI, err := url.Parse(request.RedirectURI); err == nil && redirectURI.Scheme != "" && redirectURI.Host != "" {
+			formAction += " " + redirectURI.Scheme + "://" + redirectURI.Host
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// the page may not be embedded in another site, since users enter their password on it
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; form-action "+formAction+"; frame-ancestors 'none'; base-uri 'none'")
+	w.WriteHeader(status)
+
+	if err := app.AuthorizeTemplate.Execute(w, data); err != nil {
+		app.logger(r).Error(err.Error())
+	}
+}
+
+// writeOAuthTokenResponse writes a successful access token response (RFC 6749 section 5.1). The id token is only
+// included for OpenID Connect requests
+func (app *Configs) writeOAuthTokenResponse(w http.ResponseWriter, tokenString, idToken string, scopes []string) {
+	var responseBody struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+		Scope       string `json:"scope,omitempty"`
+		IDToken     string `json:"id_token,omitempty"`
+	}
+
+	responseBody.AccessToken = tokenString
+	responseBody.TokenType = "Bearer"
+	responseBody.ExpiresIn = int64(app.AccessTokenTTL.Seconds())
+	responseBody.Scope = strings.Join(scopes, " ")
+	responseBody.IDToken = idToken
+
+	helpers.WriteJSON(w, http.StatusOK, responseBody)
+}
+
+// newIDToken generates an OpenID Connect id token for the client (OpenID Connect Core section 2). The email claims
+// are only included if the email scope was granted
+func (app *Configs) newIDToken(user *models.User, code *models.AuthorizationCode) (string, error) {
+	if _, ok := app.openIDSigningAlgorithm(); !ok {
+		return "", errorOpenIDUnavailable
+	}
+
+	claims := verify.Claims{
+		Subject:  user.UserID,
+		Audience: []string{code.ClientID},
+		Custom: map[string]any{
+			"auth_time": code.AuthTime.Unix(),
+		},
+	}
+
+	if code.Nonce != "" {
+		claims.Custom["nonce"] = code.Nonce
+	}
+
+	if slices.Contains(strings.Fields(code.Scope), scopeEmail) {
+		claims.Email = user.Email
+		claims.Custom["email_verified"] = user.IsVerified()
+	}
+
+	return app.TokenUtils.GenerateToken(claims, app.AccessTokenTTL)
+}
+
+// openIDSigningAlgorithm returns the algorithm id tokens are signed with. OpenID Connect is only available when the
+// active key is an RS256 or EdDSA key, because clients can't validate id tokens signed with the secret of the api or
+// PASETO tokens, and when the issuer is a url the endpoints in the discovery document are relative to
+func (app *Configs) openIDSigningAlgorithm() (string, bool) {
+	if !isIssuerURL(app.TokenIssuer) {
+		return "", false
+	}
+
+	activeKey, err := app.Keyring.ActiveKey()
+	if err != nil {
+		return "", false
+	}
+
+	if activeKey.Algorithm != verify.SigningMethodRS256 && activeKey.Algorithm != verify.SigningMethodEdDSA {
+		return "", false
+	}
+
+	return activeKey.Algorithm, true
+}
+
+// isIssuerURL reports whether the issuer is an absolute http(s) url without a query or fragment (OpenID Connect
+// Discovery section 3)
+func isIssuerURL(issuer string) bool {
+	issuerURL, err := url.Parse(issuer)
+	if err != nil {
+		return false
+	}
+
+	return (issuerURL.Scheme == "https" || issuerURL.Scheme == "http") && issuerURL.Host != "" && issuerURL.RawQuery == "" && issuerURL.Fragment == ""
+}
+
+// setSessionCookies sets the session and CSRF cookies of a session. Only the session cookie is HttpOnly, because
+// the CSRF token must be readable by javascript
+func setSessionCookies(w http.ResponseWriter, sessionToken, csrfToken string, expiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.SessionCookieName,
+		Value:    sessionToken,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.CSRFCookieName,
+		Value:    csrfToken,
+		Path:     "/",
+		Expires:  expiresAt,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearSessionCookies removes the session and CSRF cookies from the browser
+func clearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{middleware.SessionCookieName, middleware.CSRFCookieName} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: name == middleware.SessionCookieName,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds, rounded up
+func setRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+}
+
+// logger returns the logger for a request. Log entries include the request ID, so they can be matched with the
+// request log
+func (app *Configs) logger(r *http.Request) *slog.Logger {
+	return middleware.RequestLogger(r.Context(), app.Logger)
+}
+
+// hashPassword hashes a password in a span, since hashing is slow on purpose
+func (app *Configs) hashPassword(ctx context.Context, password string) ([]byte, error) {
+	_, span := tracer.Start(ctx, "PasswordEncryptor.GenerateHashedPassword")
+	defer span.End()
+
+	return app.PasswordEncryptor.GenerateHashedPassword(password)
+}
+
+// comparePassword compares a password with its hash in a span, since comparing is slow on purpose
+func (app *Configs) comparePassword(ctx context.Context, hashedPassword, password []byte) error {
+	_, span := tracer.Start(ctx, "PasswordEncryptor.CompareHashAndPassword")
+	defer span.End()
+
+	return app.PasswordEncryptor.CompareHashAndPassword(hashedPassword, password)
+}
+
+// dummyPasswordHash is the bcrypt hash of a random password, with the cost used for the passwords of users
+const dummyPasswordHash = "$2a$12$hFLNH35k2lE5ce46aG.nQOXeDLu.5raXWbeJRaNIIfbwfRPErTIQ."
+
+// compareUnknownUserPassword compares the password of a sign in with an unknown email against dummyPasswordHash, so
+// the response time doesn't reveal whether an account exists
+func (app *Configs) compareUnknownUserPassword(ctx context.Context, password string) {
+	_ = app.comparePassword(ctx, []byte(dummyPasswordHash), []byte(password))
+}
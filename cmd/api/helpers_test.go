@@ -2,10 +2,14 @@ package main
 
 import (
 	"auth_api/internal/helpers"
+	"auth_api/internal/verify"
+	"context"
 	"encoding/json"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestSuccessResponse(t *testing.T) {
@@ -77,3 +81,53 @@ func TestErrorResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestIsIssuerURL(t *testing.T) {
+	tests := []struct {
+		desc   string
+		issuer string
+		want   bool
+	}{
+		{desc: "name", issuer: "auth_api", want: false},
+		{desc: "relative path", issuer: "/v1", want: false},
+		{desc: "query", issuer: "https://auth.example.com/v1?tenant=1", want: false},
+		{desc: "other scheme", issuer: "ftp://auth.example.com", want: false},
+		{desc: "https", issuer: "https://auth.example.com/v1", want: true},
+		{desc: "http", issuer: "http://localhost:8080/v1", want: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			assert.Equal(t, test.want, isIssuerURL(test.issuer))
+		})
+	}
+}
+
+func TestDummyPasswordHash(t *testing.T) {
+	cost, err := bcrypt.Cost([]byte(dummyPasswordHash))
+	assert.NoError(t, err)
+	assert.Equal(t, 12, cost)
+
+	// a valid hash takes as long to compare as the hashes of users
+	err = verify.PasswordEncryptorBcrypt{}.CompareHashAndPassword([]byte(dummyPasswordHash), []byte("1234"))
+	assert.ErrorIs(t, err, bcrypt.ErrMismatchedHashAndPassword)
+}
+
+func TestRunCleanups(t *testing.T) {
+	var ran []string
+	newCleanup := func(name string, err error) cleanup {
+		return cleanup{name: name, run: func(ctx context.Context) (int64, error) {
+			ran = append(ran, name)
+			return 0, err
+		}}
+	}
+
+	err := runCleanups(context.Background(), []cleanup{
+		newCleanup("revoked tokens", errors.New("connection refused")),
+		newCleanup("sessions", nil),
+	})
+
+	// the sessions are cleaned up even though the revoked tokens couldn't be
+	assert.Equal(t, []string{"revoked tokens", "sessions"}, ran)
+	assert.EqualError(t, err, "unable to clean up revoked tokens: connection refused")
+}
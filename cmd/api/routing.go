@@ -2,39 +2,152 @@ package main
 
 import (
 	"auth_api/internal/middleware"
+	"auth_api/internal/models"
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/justinas/alice"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
-func (app *Configs) routes() http.Handler {
-	router := http.NewServeMux()
+// routePolicy is the authentication a route requires
+type routePolicy int
+
+const (
+	// policyPublic routes can be called without credentials, e.g. before a user has signed in
+	policyPublic routePolicy = iota
+	// policyUser routes require a valid bearer token or session cookie
+	policyUser
+	// policyAdmin routes require a token with the admin scope issued to a user with the admin role. Impersonated
+	// tokens are refused
+	policyAdmin
+)
+
+func (p routePolicy) String() string {
+	switch p {
+	case policyPublic:
+		return "public"
+	case policyUser:
+		return "user"
+	case policyAdmin:
+		return "admin"
+	default:
+		return fmt.Sprintf("routePolicy(%d)", int(p))
+	}
+}
+
+// rateLimitClass selects the rate limit of a route
+type rateLimitClass string
+
+const (
+	// rateLimitGeneral routes are limited per client IP
+	rateLimitGeneral rateLimitClass = "general"
+	// rateLimitAccount routes sign users in or verify them. They are limited per client IP and per email, so
+	// passwords and verification codes can't be guessed
+	rateLimitAccount rateLimitClass = "account"
+)
+
+type route struct {
+	pattern   string
+	policy    routePolicy
+	rateLimit rateLimitClass
+	handler   http.Handler
+}
 
-	router.HandleFunc("POST /auth/register", app.RegisterHandler)
-	router.HandleFunc("GET /auth/verifyuser", app.GenerateVerificationCodeHandler)
-	router.HandleFunc("POST /auth/verifyuser", app.VerifyUserHandler)
-	router.HandleFunc("POST /auth/token", app.TokenHandler)
-	router.HandleFunc("POST /auth/resetpassword", app.ResetPasswordRequestHandler)
-	router.HandleFunc("PUT /auth/resetpassword", app.ResetPasswordHandler)
-	router.HandleFunc("POST /auth/updatepassword", app.UpdatePasswordHandler)
-	router.HandleFunc("GET /auth/healthz", func(w http.ResponseWriter, r *http.Request) {
+// routeTable lists every route of the api with the authentication it requires. Routes without a rate limit class
+// use rateLimitGeneral. Route specific middleware (e.g. scopes, no-store for responses containing tokens or
+// idempotency keys for requests clients retry) is added to the handler
+func (app *Configs) routeTable() []route {
+	healthz := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	adminRouter := http.NewServeMux()
-	adminRouter.HandleFunc("DELETE /admin/auth/user", app.DeleteUserHandler)
-	adminRouter.HandleFunc("GET /auth/role", app.UserRoleHandler)
+	return []route{
+		{pattern: "GET /auth/healthz", policy: policyPublic, handler: healthz},
+		{pattern: "POST /auth/register", policy: policyPublic, handler: middleware.Idempotency(app.DB, app.IdempotencyKeyTTL)(http.HandlerFunc(app.RegisterHandler))},
+		{pattern: "GET /auth/verifyuser", policy: policyPublic, rateLimit: rateLimitAccount, handler: http.HandlerFunc(app.GenerateVerificationCodeHandler)},
+		{pattern: "POST /auth/verifyuser", policy: policyPublic, rateLimit: rateLimitAccount, handler: http.HandlerFunc(app.VerifyUserHandler)},
+		{pattern: "POST /auth/token", policy: policyPublic, rateLimit: rateLimitAccount, handler: middleware.NoStore(http.HandlerFunc(app.TokenHandler))},
+		{pattern: "POST /auth/token/refresh", policy: policyPublic, handler: middleware.NoStore(http.HandlerFunc(app.RefreshTokenHandler))},
+		{pattern: "POST /auth/session", policy: policyPublic, rateLimit: rateLimitAccount, handler: middleware.NoStore(http.HandlerFunc(app.SessionHandler))},
+		{pattern: "POST /auth/resetpassword", policy: policyPublic, rateLimit: rateLimitAccount, handler: middleware.Idempotency(app.DB, app.IdempotencyKeyTTL)(http.HandlerFunc(app.ResetPasswordRequestHandler))},
+		{pattern: "PUT /auth/resetpassword", policy: policyPublic, rateLimit: rateLimitAccount, handler: http.HandlerFunc(app.ResetPasswordHandler)},
+		{pattern: "GET /.well-known/jwks.json", policy: policyPublic, handler: http.HandlerFunc(app.JWKSHandler)},
+		{pattern: "GET /.well-known/openid-configuration", policy: policyPublic, handler: http.HandlerFunc(app.OpenIDConfigurationHandler)},
+		// OAuth clients authenticate in the handlers
+		{pattern: "POST /oauth/introspect", policy: policyPublic, handler: middleware.NoStore(http.HandlerFunc(app.IntrospectHandler))},
+		{pattern: "POST /oauth/token", policy: policyPublic, handler: middleware.NoStore(http.HandlerFunc(app.OAuthTokenHandler))},
+		{pattern: "GET /oauth/authorize", policy: policyPublic, handler: http.HandlerFunc(app.AuthorizeHandler)},
+		{pattern: "POST /oauth/authorize", policy: policyPublic, rateLimit: rateLimitAccount, handler: http.HandlerFunc(app.AuthorizeDecisionHandler)},
+
+		{pattern: "POST /auth/logout", policy: policyUser, handler: http.HandlerFunc(app.LogoutHandler)},
+		{pattern: "POST /auth/logout/all", policy: policyUser, handler: alice.New(middleware.RequireScopes(models.ScopeUsersWrite), middleware.NotImpersonated).ThenFunc(app.LogoutAllHandler)},
+		{pattern: "POST /auth/updatepassword", policy: policyUser, handler: alice.New(middleware.RequireScopes(models.ScopeUsersWrite), middleware.NotImpersonated).ThenFunc(app.UpdatePasswordHandler)},
+		{pattern: "GET /userinfo", policy: policyUser, handler: http.HandlerFunc(app.UserInfoHandler)},
+		{pattern: "POST /userinfo", policy: policyUser, handler: http.HandlerFunc(app.UserInfoHandler)},
+
+		{pattern: "GET /auth/role", policy: policyAdmin, handler: alice.New(middleware.RequireScopes(models.ScopeUsersRead)).ThenFunc(app.UserRoleHandler)},
+		{pattern: "PUT /auth/role", policy: policyAdmin, handler: http.HandlerFunc(app.UpdateUserRoleHandler)},
+		{pattern: "DELETE /admin/auth/user", policy: policyAdmin, handler: http.HandlerFunc(app.DeleteUserHandler)},
+		{pattern: "POST /admin/auth/user/logout", policy: policyAdmin, handler: http.HandlerFunc(app.LogoutUserHandler)},
+		{pattern: "POST /admin/auth/user/unlock", policy: policyAdmin, handler: http.HandlerFunc(app.UnlockUserHandler)},
+		{pattern: "POST /admin/auth/keys/rotate", policy: policyAdmin, handler: http.HandlerFunc(app.RotateSigningKeyHandler)},
+		{pattern: "POST /admin/oauth/clients", policy: policyAdmin, handler: http.HandlerFunc(app.CreateOAuthClientHandler)},
+	}
+}
+
+// policyChain returns the middleware that enforces a route policy
+func (app *Configs) policyChain(policy routePolicy) alice.Chain {
+	switch policy {
+	case policyPublic:
+		return alice.New()
+	case policyUser:
+		return alice.New(middleware.Auth(app.TokenUtils, app.DB))
+	case policyAdmin:
+		// impersonated tokens can't be used for sensitive operations
+		return alice.New(middleware.Auth(app.TokenUtils, app.DB), middleware.RequireScopes(models.ScopeAdmin), middleware.Admin(app.DB), middleware.NotImpersonated)
+	default:
+		panic(fmt.Sprintf("unknown route policy %s", policy))
+	}
+}
+
+// rateLimit returns the middleware that enforces the rate limit of a route class. Requests are limited before they
+// are authenticated
+func (app *Configs) rateLimit(class rateLimitClass) alice.Constructor {
+	switch class {
+	case rateLimitGeneral, "":
+		return middleware.RateLimit(app.RateLimitStore, string(rateLimitGeneral), app.GeneralRateLimit, middleware.IPKey(app.TrustedProxies))
+	case rateLimitAccount:
+		return middleware.RateLimit(app.RateLimitStore, string(rateLimitAccount), app.AccountRateLimit, middleware.IPKey(app.TrustedProxies), middleware.EmailKey)
+	default:
+		panic(fmt.Sprintf("unknown rate limit class %s", class))
+	}
+}
 
-	router.Handle("/admin/", middleware.Admin(app.AdminTokenSecret, adminRouter))
+func (app *Configs) routes() http.Handler {
+	router := http.NewServeMux()
+	for _, route := range app.routeTable() {
+		_, path, _ := strings.Cut(route.pattern, " ")
+		chain := alice.New(middleware.Route("/v1"+path), app.rateLimit(route.rateLimit)).Extend(app.policyChain(route.policy))
+		router.Handle(route.pattern, chain.Then(route.handler))
+	}
 
 	v1 := http.NewServeMux()
 	v1.Handle("/v1/", http.StripPrefix("/v1", router))
 
-	// stack := middleware.CreateStack(
-	// 	middleware.Logging,
-	// 	middleware.Auth([]string{app.UserTokenSecret, app.AdminTokenSecret}),
-	// )
+	handler := alice.New(
+		middleware.Logging(app.Logger, app.TrustedProxies),
+		middleware.Metrics(app.Metrics),
+		middleware.Recover(app.Logger, app.Metrics),
+		middleware.SecurityHeaders(app.HSTSMaxAge),
+		// preflight requests are answered before the routes authenticate requests
+		middleware.CORS(app.CORS),
+	).Then(v1)
 
-	return alice.New(middleware.Logging, middleware.Auth([]string{app.UserTokenSecret, app.AdminTokenSecret}), middleware.RateLimiter).Then(v1)
-	//stack(v1)
+	// every request gets a span, which continues the trace of the caller's traceparent header. The span is renamed
+	// after the route once the route matched
+	return otelhttp.NewHandler(handler, "http.request", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return r.Method
+	}))
 }
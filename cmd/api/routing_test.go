@@ -0,0 +1,173 @@
+package main
+
+import (
+	"auth_api/internal/metrics"
+	"auth_api/internal/middleware"
+	"auth_api/internal/ratelimit"
+	"auth_api/internal/verify"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// setupRoutingApp creates an app without a DB. Requests are refused by the route policies before a handler runs
+func setupRoutingApp(t *testing.T) *Configs {
+	t.Helper()
+
+	primaryKey, err := verify.NewSigningKey(primaryKeyID, verify.TokenConfig{SigningMethod: verify.SigningMethodHS256, Secret: GetTestEnv("AUTH_JWT_SECRET")})
+	if err != nil {
+		t.Fatalf("unable to create primary key: %s", err)
+	}
+
+	keyring := verify.NewKeyring([]verify.SigningKey{primaryKey}, primaryKey.KeyID)
+	tokenUtils := &verify.JWTTokenUtils{}
+	if err := tokenUtils.Setup(keyring, defaultTokenIssuer, defaultTokenAudience); err != nil {
+		t.Fatalf("unable to setup token utils: %s", err)
+	}
+
+	return &Configs{
+		Logger:  slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		Metrics: metrics.New(nil),
+		CORS: middleware.CORSConfig{
+			AllowedOrigins: []string{"https://app.example.com"},
+			AllowedMethods: []string{http.MethodGet, http.MethodPost},
+			AllowedHeaders: []string{"Authorization", "Content-Type"},
+			MaxAge:         10 * time.Minute,
+		},
+		TokenUtils:       tokenUtils,
+		Keyring:          keyring,
+		RateLimitStore:   ratelimit.NewMemoryStore(),
+		GeneralRateLimit: ratelimit.Limit{Requests: 1000, Period: time.Minute},
+		AccountRateLimit: ratelimit.Limit{Requests: 1000, Period: time.Minute},
+	}
+}
+
+func TestRoutePolicies(t *testing.T) {
+	app := setupRoutingApp(t)
+	handler := app.routes()
+
+	serve := func(pattern, authHeader string) (int, string) {
+		method, path, _ := strings.Cut(pattern, " ")
+		req, _ := http.NewRequest(method, versionUrl(path), nil)
+		if authHeader != "" {
+			req.Header.Set("Authorization", authHeader)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		resp := w.Result()
+		json, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Errorf("didn't expect error but got %s", err)
+		}
+
+		return resp.StatusCode, string(json)
+	}
+
+	for _, route := range app.routeTable() {
+		if route.policy == policyPublic {
+			continue
+		}
+
+		t.Run(fmt.Sprintf("%s route %s without credentials", route.policy, route.pattern), func(t *testing.T) {
+			status, json := serve(route.pattern, "")
+			assert.Equal(t, http.StatusUnauthorized, status)
+			assert.Equal(t, `{"status":"error","message":"authorization failed"}`, json)
+		})
+
+		if route.policy == policyAdmin {
+			t.Run(fmt.Sprintf("admin route %s with a user token", route.pattern), func(t *testing.T) {
+				status, json := serve(route.pattern, fmt.Sprintf("Bearer %s", userAuthToken(t, app.Keyring)))
+				assert.Equal(t, http.StatusForbidden, status)
+				assert.Equal(t, `{"status":"error","message":"insufficient scope"}`, json)
+			})
+		}
+	}
+
+	t.Run("public route without credentials", func(t *testing.T) {
+		status, _ := serve("GET /auth/healthz", "")
+		assert.Equal(t, http.StatusOK, status)
+	})
+
+	t.Run("unknown route", func(t *testing.T) {
+		status, _ := serve("GET /auth/unknown", "")
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+}
+
+func TestRouteMetrics(t *testing.T) {
+	app := setupRoutingApp(t)
+	handler := app.routes()
+
+	for _, path := range []string{"/auth/healthz", "/auth/healthz", "/unknown/1", "/unknown/2"} {
+		req, _ := http.NewRequest(http.MethodGet, versionUrl(path), nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	app.Metrics.Handler().ServeHTTP(w, req)
+
+	// requests are counted per route, unknown paths are counted together
+	assert.Contains(t, w.Body.String(), `auth_api_http_requests_total{method="GET",route="/v1/auth/healthz",status="200"} 2`)
+	assert.Contains(t, w.Body.String(), `auth_api_http_requests_total{method="GET",route="unmatched",status="404"} 2`)
+}
+
+func TestRouteTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	app := setupRoutingApp(t)
+	req, _ := http.NewRequest(http.MethodGet, versionUrl("/auth/healthz"), nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	app.routes().ServeHTTP(httptest.NewRecorder(), req)
+
+	// the span continues the trace of the caller and is named after the route
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "GET /v1/auth/healthz", spans[0].Name())
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+	}
+}
+
+func TestRouteCORS(t *testing.T) {
+	app := setupRoutingApp(t)
+	handler := app.routes()
+
+	// preflight requests don't send credentials, so they are answered before the route authenticates the request
+	req, _ := http.NewRequest(http.MethodOptions, versionUrl("/auth/logout"), nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	req.Header.Set("Access-Control-Request-Headers", "Authorization")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+
+	req, _ = http.NewRequest(http.MethodPost, versionUrl("/auth/logout"), nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, middleware.ContentSecurityPolicy, w.Header().Get("Content-Security-Policy"))
+}
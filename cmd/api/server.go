@@ -1,17 +1,24 @@
 package main
 
 import (
+	"auth_api/internal/metrics"
+	"auth_api/internal/middleware"
+	"auth_api/internal/ratelimit"
 	"auth_api/internal/storage"
 	"auth_api/internal/storage/database"
+	"auth_api/internal/tracing"
 	"auth_api/internal/verify"
+	"auth_api/static"
 	"context"
 	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"slices"
 	"sync"
 	"time"
 
@@ -24,49 +31,235 @@ type Configs struct {
 	Verifier          verify.UserVerifier
 	PasswordEncryptor verify.PasswordEncryptor
 	TokenUtils        verify.TokenUtils
-	UserTokenSecret   string
-	AdminTokenSecret  string
+	RefreshTokens     verify.RefreshTokenGenerator
+	Keyring           *verify.Keyring
+	// KeyEncryptor encrypts the keys that are rotated using the api before they are stored in the DB. Keys can only be
+	// rotated when it is set
+	KeyEncryptor *verify.KeyEncryptor
+	// EnvSigningKeys are the keys configured using environment variables. The first key is used for signing until
+	// a key is rotated
+	EnvSigningKeys        []verify.SigningKey
+	AccessTokenTTL        time.Duration
+	RefreshTokenTTL       time.Duration
+	KeyringReloadInterval time.Duration
+	// TokenIssuer and TokenAudience are the iss and aud claims of issued tokens. Tokens with other values are rejected
+	TokenIssuer   string
+	TokenAudience string
+	// CleanupInterval is how often expired revoked tokens, authorization codes, sessions, idempotency keys, idle rate
+	// limit buckets and forgotten login attempts are removed
+	CleanupInterval time.Duration
+	// IntrospectionClientID and IntrospectionClientSecret are the credentials resource servers use to call the
+	// token introspection endpoint
+	IntrospectionClientID     string
+	IntrospectionClientSecret string
+	// AuthorizationCodeTTL is how long an authorization code can be exchanged for a token
+	AuthorizationCodeTTL time.Duration
+	AuthorizeTemplate    *template.Template
+	// SessionTTL is how long a cookie session stays valid after the user signed in
+	SessionTTL time.Duration
+	// ImpersonationTokenTTL is how long a token issued to an admin impersonating a user is valid
+	ImpersonationTokenTTL time.Duration
+	// RateLimitStore keeps the token buckets of the rate limits. GeneralRateLimit applies to all routes per client IP
+	// and AccountRateLimit to routes that sign users in or verify them, per client IP and per email
+	RateLimitStore   ratelimit.Store
+	GeneralRateLimit ratelimit.Limit
+	AccountRateLimit ratelimit.Limit
+	// TrustedProxies may set the X-Forwarded-For header used to find the client IP
+	TrustedProxies middleware.TrustedProxies
+	// LoginLockout delays and locks sign in after failed attempts for an email. IPLoginLockout locks sign in from a
+	// client IP after failed attempts for any email
+	LoginLockout   verify.LoginLockout
+	IPLoginLockout verify.LoginLockout
+	// Metrics are served in the Prometheus format on the metrics listener
+	Metrics *metrics.Metrics
+	// CORS configures which browser clients on other origins can call the api
+	CORS middleware.CORSConfig
+	// HSTSMaxAge is how long browsers only use HTTPS for the api. HSTS is disabled when it is zero
+	HSTSMaxAge time.Duration
+	// IdempotencyKeyTTL is how long the response to a request with an Idempotency-Key header is replayed
+	IdempotencyKeyTTL time.Duration
 }
 
+const (
+	primaryKeyID = "primary"
+
+	defaultTokenIssuer   = "auth_api"
+	defaultTokenAudience = "auth_api"
+
+	rateLimitStoreMemory   = "memory"
+	rateLimitStorePostgres = "postgres"
+)
+
 type App struct {
-	server  *http.Server
-	configs *Configs
-	db      *sqlx.DB
+	server *http.Server
+	// metricsServer serves the metrics on a separate listener, so they aren't exposed on the public api. It is nil
+	// when AUTH_METRICS_ADDR isn't set
+	metricsServer *http.Server
+	configs       *Configs
+	db            *sqlx.DB
+	// shutdownTracing flushes the spans that weren't exported yet
+	shutdownTracing func(context.Context) error
 }
 
-func NewServer(w io.Writer, getenv func(string) string, dbConnStr string, verifier verify.UserVerifier, passwordEncryptor verify.PasswordEncryptor, TokenUtils verify.TokenUtils) (*App, error) {
+// NewServer creates the api using environment variables read using getenv. When TokenUtils is nil, PASETO tokens are
+// issued if AUTH_JWT_SIGNING_METHOD is v4.public or v4.local and JWTs are issued otherwise
+func NewServer(w io.Writer, getenv func(string) string, dbConnStr string, verifier verify.UserVerifier, passwordEncryptor verify.PasswordEncryptor, TokenUtils verify.TokenUtils, refreshTokens verify.RefreshTokenGenerator) (*App, error) {
 	logger := slog.New(slog.NewJSONHandler(w, nil))
 
 	EnvReader := NewEnvReader(getenv)
 
 	hostAddr := EnvReader.GetString("AUTH_HOST_ADDR")
 	hostPort := EnvReader.GetString("AUTH_HOST_PORT", "80")
+	metricsAddr := EnvReader.GetString("AUTH_METRICS_ADDR")
 
 	dbConnectionStr := dbConnStr
 	if dbConnectionStr == "" {
 		dbConnectionStr = EnvReader.GetString("AUTH_DB_CONNECTION_STRING")
 	}
 
-	jwtSecret := EnvReader.GetString("AUTH_JWT_SECRET")
-	if jwtSecret == "" {
-		return nil, errors.New("AUTH_JWT_SECRET environment variable requires a value")
+	tokenConfig := verify.TokenConfig{
+		SigningMethod: EnvReader.GetString("AUTH_JWT_SIGNING_METHOD", verify.SigningMethodHS256),
+		Secret:        EnvReader.GetString("AUTH_JWT_SECRET"),
+	}
+
+	switch tokenConfig.SigningMethod {
+	case verify.SigningMethodHS256, verify.SigningMethodPASETOV4Local:
+		if tokenConfig.Secret == "" {
+			return nil, errors.New("AUTH_JWT_SECRET environment variable requires a value")
+		}
+	case verify.SigningMethodRS256, verify.SigningMethodEdDSA, verify.SigningMethodPASETOV4Public:
+		privateKeyFile := EnvReader.GetString("AUTH_JWT_PRIVATE_KEY_FILE")
+		if privateKeyFile == "" {
+			return nil, fmt.Errorf("AUTH_JWT_PRIVATE_KEY_FILE environment variable requires a value when using %s", tokenConfig.SigningMethod)
+		}
+
+		privateKeyPEM, err := os.ReadFile(privateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read AUTH_JWT_PRIVATE_KEY_FILE: %w", err)
+		}
+
+		tokenConfig.PrivateKeyPEM = privateKeyPEM
+	default:
+		return nil, fmt.Errorf("AUTH_JWT_SIGNING_METHOD must be one of %s, %s, %s, %s or %s", verify.SigningMethodHS256, verify.SigningMethodRS256, verify.SigningMethodEdDSA, verify.SigningMethodPASETOV4Public, verify.SigningMethodPASETOV4Local)
 	}
 
-	userTokenSecret := EnvReader.GetString("AUTH_USER_TOKEN_SECRET")
-	if userTokenSecret == "" {
-		return nil, errors.New("AUTH_USER_TOKEN_SECRET environment variable requires a value")
+	if TokenUtils == nil {
+		TokenUtils = &verify.JWTTokenUtils{}
+		if verify.IsPASETOSigningMethod(tokenConfig.SigningMethod) {
+			TokenUtils = &verify.PASETOTokenUtils{}
+		}
 	}
 
-	adminTokenSecret := EnvReader.GetString("AUTH_ADMIN_TOKEN_SECRET")
-	if userTokenSecret == "" {
-		return nil, errors.New("AUTH_ADMIN_TOKEN_SECRET environment variable requires a value")
+	tokenIssuer := EnvReader.GetString("AUTH_JWT_ISSUER", defaultTokenIssuer)
+	tokenAudience := EnvReader.GetString("AUTH_JWT_AUDIENCE", defaultTokenAudience)
+
+	// RS256 and EdDSA keys are used for OpenID Connect, which derives the endpoints in the discovery document from the
+	// issuer
+	isOpenIDSigningMethod := tokenConfig.SigningMethod == verify.SigningMethodRS256 || tokenConfig.SigningMethod == verify.SigningMethodEdDSA
+	if isOpenIDSigningMethod && !isIssuerURL(tokenIssuer) {
+		return nil, fmt.Errorf("AUTH_JWT_ISSUER must be a url (e.g. https://auth.example.com/v1) when using %s", tokenConfig.SigningMethod)
 	}
 
 	verificationCodeLength := EnvReader.GetInt("AUTH_VERIFICATION_CODE_LENGTH", 6)
 	verificationMaxRetries := EnvReader.GetInt("AUTH_VERIFICATION_MAX_RETRIES", 6)
 
+	accessTokenTTL := EnvReader.GetDuration("AUTH_ACCESS_TOKEN_LIFETIME", 24*time.Hour)
+	refreshTokenTTL := EnvReader.GetDuration("AUTH_REFRESH_TOKEN_LIFETIME", 30*24*time.Hour)
+	keyringReloadInterval := EnvReader.GetDuration("AUTH_KEYRING_RELOAD_INTERVAL", time.Minute)
+	if keyringReloadInterval <= 0 {
+		return nil, errors.New("AUTH_KEYRING_RELOAD_INTERVAL must be greater than zero")
+	}
+
+	// rotating signing keys stores them in the DB, which is only allowed when they can be encrypted
+	var keyEncryptor *verify.KeyEncryptor
+	if encryptionKey := EnvReader.GetString("AUTH_SIGNING_KEY_ENCRYPTION_KEY"); encryptionKey != "" {
+		var err error
+		keyEncryptor, err = verify.NewKeyEncryptor(encryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("AUTH_SIGNING_KEY_ENCRYPTION_KEY: %w", err)
+		}
+	}
+
+	authorizationCodeTTL := EnvReader.GetDuration("AUTH_AUTHORIZATION_CODE_LIFETIME", time.Minute)
+	sessionTTL := EnvReader.GetDuration("AUTH_SESSION_LIFETIME", 24*time.Hour)
+	impersonationTokenTTL := EnvReader.GetDuration("AUTH_IMPERSONATION_TOKEN_LIFETIME", 15*time.Minute)
+
+	generalRateLimit, err := ratelimit.ParseLimit(EnvReader.GetString("AUTH_RATE_LIMIT_GENERAL", "300/1m"))
+	if err != nil {
+		return nil, fmt.Errorf("AUTH_RATE_LIMIT_GENERAL: %w", err)
+	}
+
+	accountRateLimit, err := ratelimit.ParseLimit(EnvReader.GetString("AUTH_RATE_LIMIT_ACCOUNT", "10/1m"))
+	if err != nil {
+		return nil, fmt.Errorf("AUTH_RATE_LIMIT_ACCOUNT: %w", err)
+	}
+
+	rateLimitStore := EnvReader.GetString("AUTH_RATE_LIMIT_STORE", rateLimitStoreMemory)
+	if rateLimitStore != rateLimitStoreMemory && rateLimitStore != rateLimitStorePostgres {
+		return nil, fmt.Errorf("AUTH_RATE_LIMIT_STORE must be %s or %s", rateLimitStoreMemory, rateLimitStorePostgres)
+	}
+
+	trustedProxies, err := middleware.ParseTrustedProxies(EnvReader.GetString("AUTH_TRUSTED_PROXIES"))
+	if err != nil {
+		return nil, fmt.Errorf("AUTH_TRUSTED_PROXIES: %w", err)
+	}
+
+	loginLockout := verify.LoginLockout{
+		MaxFailures:     EnvReader.GetInt("AUTH_LOGIN_MAX_FAILURES", 5),
+		BaseDelay:       EnvReader.GetDuration("AUTH_LOGIN_BASE_DELAY", time.Second),
+		LockoutDuration: EnvReader.GetDuration("AUTH_LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+	}
+	if loginLockout.MaxFailures <= 0 || loginLockout.LockoutDuration <= 0 {
+		return nil, errors.New("AUTH_LOGIN_MAX_FAILURES and AUTH_LOGIN_LOCKOUT_DURATION must be greater than zero")
+	}
+
+	// clients behind the same IP share the IP lockout, so it isn't delayed
+	ipLoginLockout := verify.LoginLockout{
+		MaxFailures:     EnvReader.GetInt("AUTH_LOGIN_IP_MAX_FAILURES", 20),
+		LockoutDuration: loginLockout.LockoutDuration,
+	}
+	if ipLoginLockout.MaxFailures <= 0 {
+		return nil, errors.New("AUTH_LOGIN_IP_MAX_FAILURES must be greater than zero")
+	}
+
+	cors := middleware.CORSConfig{
+		AllowedOrigins:   middleware.ParseList(EnvReader.GetString("AUTH_CORS_ALLOWED_ORIGINS")),
+		AllowedMethods:   middleware.ParseList(EnvReader.GetString("AUTH_CORS_ALLOWED_METHODS", "GET, POST, PUT, DELETE")),
+		AllowedHeaders:   middleware.ParseList(EnvReader.GetString("AUTH_CORS_ALLOWED_HEADERS", "Authorization, Content-Type, X-CSRF-Token, X-Request-ID, Idempotency-Key")),
+		AllowCredentials: EnvReader.GetBool("AUTH_CORS_ALLOW_CREDENTIALS"),
+		MaxAge:           EnvReader.GetDuration("AUTH_CORS_MAX_AGE", 10*time.Minute),
+	}
+	// allowing any origin with credentials would let every site use the sessions of signed in users
+	if cors.AllowCredentials && slices.Contains(cors.AllowedOrigins, "*") {
+		return nil, errors.New("AUTH_CORS_ALLOWED_ORIGINS can't contain * when AUTH_CORS_ALLOW_CREDENTIALS is true")
+	}
+
+	idempotencyKeyTTL := EnvReader.GetDuration("AUTH_IDEMPOTENCY_KEY_TTL", 24*time.Hour)
+	if idempotencyKeyTTL <= 0 {
+		return nil, errors.New("AUTH_IDEMPOTENCY_KEY_TTL must be greater than zero")
+	}
+
+	cleanupInterval := EnvReader.GetDuration("AUTH_CLEANUP_INTERVAL", time.Hour)
+	if cleanupInterval <= 0 {
+		return nil, errors.New("AUTH_CLEANUP_INTERVAL must be greater than zero")
+	}
+
+	primaryKey, err := verify.NewSigningKey(EnvReader.GetString("AUTH_JWT_KEY_ID", primaryKeyID), tokenConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:     EnvReader.GetString("AUTH_TRACING_EXPORTER", tracing.ExporterNone),
+		OTLPEndpoint: EnvReader.GetString("AUTH_TRACING_OTLP_ENDPOINT"),
+		Stdout:       w,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("AUTH_TRACING_EXPORTER: %w", err)
+	}
+
 	// connect to DB
-	db, err := database.ConnectToPostgres(dbConnectionStr)
+	db, err := database.ConnectToPostgres(dbConnectionStr, logger, EnvReader.GetDuration("AUTH_SLOW_QUERY_THRESHOLD", 200*time.Millisecond))
 	if err != nil {
 		return nil, err
 	}
@@ -74,17 +267,67 @@ func NewServer(w io.Writer, getenv func(string) string, dbConnStr string, verifi
 	// defer db.Close()
 
 	verifier.Setup(verificationCodeLength, verificationMaxRetries)
-	TokenUtils.Setup(jwtSecret)
+
+	keyring := verify.NewKeyring([]verify.SigningKey{primaryKey}, primaryKey.KeyID)
+	if err := TokenUtils.Setup(keyring, tokenIssuer, tokenAudience); err != nil {
+		return nil, err
+	}
+
+	authorizeTemplate, err := template.ParseFS(static.Files, "authorize.html")
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse authorize page: %w", err)
+	}
 
 	var dbrepo storage.DBRepo = database.NewPostgresDBRepo(db)
+	appMetrics := metrics.New(db.DB)
+
+	// replicas of the api only share rate limits when they are stored in the DB
+	var rateLimits ratelimit.Store = ratelimit.NewMemoryStore()
+	if rateLimitStore == rateLimitStorePostgres {
+		rateLimits = ratelimit.NewDBStore(dbrepo)
+	}
+
 	configs := Configs{
-		DB:                dbrepo,
-		Logger:            logger,
-		Verifier:          verifier,
-		PasswordEncryptor: passwordEncryptor,
-		TokenUtils:        TokenUtils,
-		UserTokenSecret:   userTokenSecret,
-		AdminTokenSecret:  adminTokenSecret,
+		DB:                        dbrepo,
+		Logger:                    logger,
+		Verifier:                  verifier,
+		PasswordEncryptor:         appMetrics.PasswordEncryptor(passwordEncryptor),
+		TokenUtils:                TokenUtils,
+		RefreshTokens:             refreshTokens,
+		Keyring:                   keyring,
+		EnvSigningKeys:            []verify.SigningKey{primaryKey},
+		KeyEncryptor:              keyEncryptor,
+		AccessTokenTTL:            accessTokenTTL,
+		RefreshTokenTTL:           refreshTokenTTL,
+		KeyringReloadInterval:     keyringReloadInterval,
+		TokenIssuer:               tokenIssuer,
+		TokenAudience:             tokenAudience,
+		CleanupInterval:           cleanupInterval,
+		IntrospectionClientID:     EnvReader.GetString("AUTH_INTROSPECTION_CLIENT_ID"),
+		IntrospectionClientSecret: EnvReader.GetString("AUTH_INTROSPECTION_CLIENT_SECRET"),
+		AuthorizationCodeTTL:      authorizationCodeTTL,
+		AuthorizeTemplate:         authorizeTemplate,
+		SessionTTL:                sessionTTL,
+		ImpersonationTokenTTL:     impersonationTokenTTL,
+		RateLimitStore:            rateLimits,
+		GeneralRateLimit:          generalRateLimit,
+		AccountRateLimit:          accountRateLimit,
+		TrustedProxies:            trustedProxies,
+		LoginLockout:              loginLockout,
+		IPLoginLockout:            ipLoginLockout,
+		Metrics:                   appMetrics,
+		CORS:                      cors,
+		HSTSMaxAge:                EnvReader.GetDuration("AUTH_HSTS_MAX_AGE", 2*365*24*time.Hour),
+		IdempotencyKeyTTL:         idempotencyKeyTTL,
+	}
+
+	// add the keys that were rotated using the api
+	if err := configs.loadSigningKeys(context.Background()); err != nil {
+		return nil, err
+	}
+
+	if err := configs.bootstrapAdmin(context.Background(), EnvReader.GetString("AUTH_BOOTSTRAP_ADMIN_EMAIL"), EnvReader.GetString("AUTH_BOOTSTRAP_ADMIN_PASSWORD")); err != nil {
+		return nil, fmt.Errorf("unable to bootstrap admin user: %w", err)
 	}
 
 	srv := http.Server{
@@ -92,10 +335,22 @@ func NewServer(w io.Writer, getenv func(string) string, dbConnStr string, verifi
 		Handler: configs.routes(),
 	}
 
+	var metricsSrv *http.Server
+	if metricsAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("GET /metrics", appMetrics.Handler())
+		metricsSrv = &http.Server{
+			Addr:    metricsAddr,
+			Handler: metricsMux,
+		}
+	}
+
 	return &App{
-		server:  &srv,
-		configs: &configs,
-		db:      db,
+		server:          &srv,
+		metricsServer:   metricsSrv,
+		configs:         &configs,
+		db:              db,
+		shutdownTracing: shutdownTracing,
 	}, nil
 }
 
@@ -107,20 +362,106 @@ func run(ctx context.Context, app *App) error {
 			fmt.Fprintf(os.Stderr, "error listening and serving: %s\n", err)
 		}
 	}()
+	if app.metricsServer != nil {
+		go func() {
+			app.configs.Logger.Info(fmt.Sprintf("Starting metrics server on %s", app.metricsServer.Addr))
+
+			if err := app.metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fmt.Fprintf(os.Stderr, "error listening and serving metrics: %s\n", err)
+			}
+		}()
+	}
 	var wg sync.WaitGroup
-	wg.Add(1)
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+
+		// other instances of the api could have rotated the signing key
+		app.configs.runEvery(ctx, app.configs.KeyringReloadInterval, app.configs.loadSigningKeys)
+	}()
+	go func() {
+		defer wg.Done()
+
+		cleanups := app.configs.cleanups()
+		app.configs.runEvery(ctx, app.configs.CleanupInterval, func(ctx context.Context) error {
+			return runCleanups(ctx, cleanups)
+		})
+	}()
 	go func() {
 		defer wg.Done()
 		<-ctx.Done()
 
-		shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		// ctx is already done, the shutdown needs its own deadline to finish requests and flush spans
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
 		defer cancel()
 		if err := app.server.Shutdown(shutdownCtx); err != nil {
 			fmt.Fprintf(os.Stderr, "error shutting down http server: %s\n", err)
 		}
 
+		if app.metricsServer != nil {
+			if err := app.metricsServer.Shutdown(shutdownCtx); err != nil {
+				fmt.Fprintf(os.Stderr, "error shutting down metrics server: %s\n", err)
+			}
+		}
+
+		if err := app.shutdownTracing(shutdownCtx); err != nil {
+			fmt.Fprintf(os.Stderr, "error shutting down tracing: %s\n", err)
+		}
+
 	}()
 	wg.Wait()
 
 	return nil
 }
+
+// cleanup removes expired rows that are no longer needed from a table
+type cleanup struct {
+	name string
+	run  func(ctx context.Context) (int64, error)
+}
+
+// cleanups returns the cleanups that run every CleanupInterval
+func (app *Configs) cleanups() []cleanup {
+	return []cleanup{
+		{name: "revoked tokens", run: app.DB.DeleteExpiredRevokedTokens},
+		{name: "authorization codes", run: app.DB.DeleteExpiredAuthorizationCodes},
+		{name: "rate limits", run: func(ctx context.Context) (int64, error) {
+			return app.RateLimitStore.DeleteIdle(ctx, max(app.GeneralRateLimit.Period, app.AccountRateLimit.Period))
+		}},
+		{name: "login attempts", run: func(ctx context.Context) (int64, error) {
+			return app.DB.DeleteExpiredLoginAttempts(ctx, app.LoginLockout.LockoutDuration)
+		}},
+		{name: "idempotency keys", run: app.DB.DeleteExpiredIdempotencyKeys},
+		{name: "sessions", run: app.DB.DeleteExpiredSessions},
+	}
+}
+
+// runCleanups runs all the cleanups. A failing cleanup doesn't stop the cleanups after it, so one broken table can't
+// keep the others from being cleaned up
+func runCleanups(ctx context.Context, cleanups []cleanup) error {
+	var errs []error
+	for _, c := range cleanups {
+		if _, err := c.run(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("unable to clean up %s: %w", c.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// runEvery calls fn every interval until ctx is done. Errors are logged
+func (app *Configs) runEvery(ctx context.Context, interval time.Duration, fn func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := fn(ctx); err != nil {
+				app.Logger.Error(err.Error())
+			}
+		}
+	}
+}
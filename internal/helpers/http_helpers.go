@@ -35,6 +35,19 @@ func ErrorResponse(message string) *jsonResponse {
 	}
 }
 
+type oauthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// OAuthErrorResponse creates an error struct based on the OAuth 2.0 specification (RFC 6749 section 5.2)
+func OAuthErrorResponse(code, description string) *oauthErrorResponse {
+	return &oauthErrorResponse{
+		Error:            code,
+		ErrorDescription: description,
+	}
+}
+
 func WriteJSON(w http.ResponseWriter, status int, data any) error {
 	out, err := json.Marshal(data)
 	if err != nil {
@@ -63,3 +76,11 @@ func ReadJSON(w http.ResponseWriter, r *http.Request, data any) error {
 	dec := json.NewDecoder(r.Body)
 	return dec.Decode(&data)
 }
+
+// ReadForm parses an application/x-www-form-urlencoded request body into r.PostForm
+func ReadForm(w http.ResponseWriter, r *http.Request) error {
+	maxBytes := 1048576
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	return r.ParseForm()
+}
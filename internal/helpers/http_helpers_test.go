@@ -76,3 +76,27 @@ func TestErrorResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestOAuthErrorResponse(t *testing.T) {
+	tests := []struct {
+		desc        string
+		code        string
+		description string
+		want        string
+	}{
+		{desc: "code only", code: "invalid_client", description: "", want: `{"error":"invalid_client"}`},
+		{desc: "code and description", code: "invalid_request", description: "token is required", want: `{"error":"invalid_request","error_description":"token is required"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			response := OAuthErrorResponse(test.code, test.description)
+			b, err := json.Marshal(response)
+			if err != nil {
+				t.Errorf("unexpected error while marshalling struct to json: %s", err.Error())
+			}
+
+			assert.Equal(t, test.want, string(b))
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package metrics
+
+import (
+	"auth_api/internal/verify"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "auth_api"
+
+// Reasons a sign in attempt failed
+const (
+	LoginFailureInvalidCredentials = "invalid_credentials"
+	LoginFailureNotActive          = "not_active"
+	LoginFailureLocked             = "locked"
+)
+
+// Metrics collects the metrics of the api in its own registry, so several instances (e.g. in tests) don't conflict
+type Metrics struct {
+	registry             *prometheus.Registry
+	httpRequests         *prometheus.CounterVec
+	httpRequestDuration  *prometheus.HistogramVec
+	logins               prometheus.Counter
+	loginFailures        *prometheus.CounterVec
+	registrations        prometheus.Counter
+	verifications        *prometheus.CounterVec
+	passwordResets       *prometheus.CounterVec
+	passwordHashDuration *prometheus.HistogramVec
+	panics               prometheus.Counter
+}
+
+// New creates the metrics. The connection pool statistics of db are included when db isn't nil
+func New(db *sql.DB) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by method, route and status code.",
+		}, []string{"method", "route", "status"}),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of HTTP requests by method and route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		logins: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "logins_total",
+			Help:      "Number of successful sign ins.",
+		}),
+		loginFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "login_failures_total",
+			Help:      "Number of failed sign ins by reason.",
+		}, []string{"reason"}),
+		registrations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "registrations_total",
+			Help:      "Number of registered users.",
+		}),
+		verifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "verifications_total",
+			Help:      "Number of user verification attempts by result.",
+		}, []string{"result"}),
+		passwordResets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "password_resets_total",
+			Help:      "Number of password resets by stage (requested or completed).",
+		}, []string{"stage"}),
+		passwordHashDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "password_hash_duration_seconds",
+			Help:      "Duration of hashing and comparing passwords.",
+			// bcrypt is slow on purpose
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 10),
+		}, []string{"operation"}),
+		panics: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "panics_total",
+			Help:      "Number of panics recovered from in HTTP handlers.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.httpRequests,
+		m.httpRequestDuration,
+		m.logins,
+		m.loginFailures,
+		m.registrations,
+		m.verifications,
+		m.passwordResets,
+		m.passwordHashDuration,
+		m.panics,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	if db != nil {
+		m.registry.MustRegister(collectors.NewDBStatsCollector(db, namespace))
+	}
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// ObserveRequest counts a HTTP request and records its duration
+func (m *Metrics) ObserveRequest(method, route string, status int, duration time.Duration) {
+	m.httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	m.httpRequestDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// LoginSucceeded counts a successful sign in
+func (m *Metrics) LoginSucceeded() {
+	m.logins.Inc()
+}
+
+// LoginFailed counts a failed sign in. reason is one of the LoginFailure constants
+func (m *Metrics) LoginFailed(reason string) {
+	m.loginFailures.WithLabelValues(reason).Inc()
+}
+
+// UserRegistered counts a registered user
+func (m *Metrics) UserRegistered() {
+	m.registrations.Inc()
+}
+
+// UserVerified counts a user verification attempt
+func (m *Metrics) UserVerified(success bool) {
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+
+	m.verifications.WithLabelValues(result).Inc()
+}
+
+// PasswordResetRequested counts a password reset code sent to a user
+func (m *Metrics) PasswordResetRequested() {
+	m.passwordResets.WithLabelValues("requested").Inc()
+}
+
+// PasswordResetCompleted counts a password changed using a reset code
+func (m *Metrics) PasswordResetCompleted() {
+	m.passwordResets.WithLabelValues("completed").Inc()
+}
+
+// ObservePasswordHash records how long hashing (operation "hash") or comparing (operation "compare") a password took
+func (m *Metrics) ObservePasswordHash(operation string, duration time.Duration) {
+	m.passwordHashDuration.WithLabelValues(operation).Observe(duration.Seconds())
+}
+
+// PanicRecovered counts a panic in a HTTP handler
+func (m *Metrics) PanicRecovered() {
+	m.panics.Inc()
+}
+
+// timedPasswordEncryptor records the duration of the password operations of another PasswordEncryptor
+type timedPasswordEncryptor struct {
+	next    verify.PasswordEncryptor
+	metrics *Metrics
+}
+
+// PasswordEncryptor returns a PasswordEncryptor that records the duration of the operations of next
+func (m *Metrics) PasswordEncryptor(next verify.PasswordEncryptor) verify.PasswordEncryptor {
+	return timedPasswordEncryptor{next: next, metrics: m}
+}
+
+func (e timedPasswordEncryptor) GenerateHashedPassword(password string) ([]byte, error) {
+	start := time.Now()
+	defer func() { e.metrics.ObservePasswordHash("hash", time.Since(start)) }()
+
+	return e.next.GenerateHashedPassword(password)
+}
+
+func (e timedPasswordEncryptor) CompareHashAndPassword(hashedPassword, password []byte) error {
+	start := time.Now()
+	defer func() { e.metrics.ObservePasswordHash("compare", time.Since(start)) }()
+
+	return e.next.CompareHashAndPassword(hashedPassword, password)
+}
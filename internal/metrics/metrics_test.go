@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testPasswordEncryptor struct{}
+
+func (e testPasswordEncryptor) GenerateHashedPassword(password string) ([]byte, error) {
+	return []byte(password), nil
+}
+
+func (e testPasswordEncryptor) CompareHashAndPassword(hashedPassword, password []byte) error {
+	if string(hashedPassword) != string(password) {
+		return errors.New("passwords don't match")
+	}
+
+	return nil
+}
+
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, err := io.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Fatalf("didn't expect error but got %s", err)
+	}
+
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	m := New(nil)
+
+	m.ObserveRequest(http.MethodPost, "/v1/auth/token", http.StatusOK, 10*time.Millisecond)
+	m.LoginSucceeded()
+	m.LoginFailed(LoginFailureInvalidCredentials)
+	m.LoginFailed(LoginFailureInvalidCredentials)
+	m.LoginFailed(LoginFailureLocked)
+	m.UserRegistered()
+	m.UserVerified(true)
+	m.UserVerified(false)
+	m.PasswordResetRequested()
+	m.PasswordResetCompleted()
+	m.PanicRecovered()
+
+	encryptor := m.PasswordEncryptor(testPasswordEncryptor{})
+	hashed, err := encryptor.GenerateHashedPassword("1234")
+	assert.NoError(t, err)
+	assert.NoError(t, encryptor.CompareHashAndPassword(hashed, []byte("1234")))
+	assert.Error(t, encryptor.CompareHashAndPassword(hashed, []byte("4321")))
+
+	body := scrape(t, m)
+	for _, want := range []string{
+		`auth_api_http_requests_total{method="POST",route="/v1/auth/token",status="200"} 1`,
+		`auth_api_http_request_duration_seconds_count{method="POST",route="/v1/auth/token"} 1`,
+		`auth_api_logins_total 1`,
+		`auth_api_login_failures_total{reason="invalid_credentials"} 2`,
+		`auth_api_login_failures_total{reason="locked"} 1`,
+		`auth_api_registrations_total 1`,
+		`auth_api_verifications_total{result="success"} 1`,
+		`auth_api_verifications_total{result="failure"} 1`,
+		`auth_api_password_resets_total{stage="requested"} 1`,
+		`auth_api_password_resets_total{stage="completed"} 1`,
+		`auth_api_password_hash_duration_seconds_count{operation="hash"} 1`,
+		`auth_api_password_hash_duration_seconds_count{operation="compare"} 2`,
+		`auth_api_panics_total 1`,
+		`go_goroutines`,
+	} {
+		assert.Contains(t, body, want)
+	}
+}
@@ -2,33 +2,61 @@ package middleware
 
 import (
 	"auth_api/internal/helpers"
+	"auth_api/internal/models"
+	"auth_api/internal/storage"
 	"auth_api/internal/verify"
+	"context"
+	"database/sql"
+	"errors"
 	"net/http"
-	"strings"
 )
 
-func Admin(secret string, next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tokenString := r.Header.Get("Authorization")
-		if tokenString == "" {
-			helpers.WriteJSON(w, http.StatusForbidden, helpers.ErrorResponse("admin access rights required"))
-			return
-		}
-
-		tokenParts := strings.Split(tokenString, " ")
-		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-			helpers.WriteJSON(w, http.StatusForbidden, helpers.ErrorResponse("admin access rights required"))
-			return
-		}
-
-		tokenUtils := verify.JWTTokenUtils{}
-		tokenUtils.Setup(secret)
-		err := tokenUtils.ValidateToken(tokenParts[1])
-		if err != nil {
-			helpers.WriteJSON(w, http.StatusForbidden, helpers.ErrorResponse("admin access rights required"))
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
+// Admin refuses callers that are not admin users. It must run after Auth
+func Admin(db storage.DBRepo) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				helpers.WriteJSON(w, http.StatusForbidden, helpers.ErrorResponse("admin access rights required"))
+				return
+			}
+
+			isAdmin, err := IsAdmin(r.Context(), db, claims)
+			if err != nil {
+				helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse("admin verification failed"))
+				return
+			}
+
+			if !isAdmin {
+				helpers.WriteJSON(w, http.StatusForbidden, helpers.ErrorResponse("admin access rights required"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// IsAdmin returns true if the claims were issued to an admin user. The role claim is checked against the role stored
+// in the DB, so users that are no longer admins lose their access rights before their tokens expire. Tokens issued to
+// OAuth clients on behalf of an admin are refused
+func IsAdmin(ctx context.Context, db storage.DBRepo, claims *verify.Claims) (bool, error) {
+	if _, ok := claims.Custom["client_id"]; ok {
+		return false, nil
+	}
+
+	if claims.Role != models.RoleAdmin || !claims.IsUserToken() {
+		return false, nil
+	}
+
+	user, err := db.GetUserByID(ctx, claims.Subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return user.IsAdmin() && user.Status == models.UserStatusActive, nil
 }
@@ -2,48 +2,122 @@ package middleware
 
 import (
 	"auth_api/internal/helpers"
+	"auth_api/internal/storage"
 	"auth_api/internal/verify"
+	"context"
+	"database/sql"
+	"errors"
 	"net/http"
 	"strings"
 )
 
-func Auth(secrets []string) func(next http.Handler) http.Handler {
+type contextKey string
+
+const claimsContextKey = contextKey("claims")
+
+// ErrTokenRevoked is returned for tokens that were revoked, or that were issued before the token version of the user
+// was increased
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// Auth authenticates requests using a bearer token or a session cookie. The claims of the caller are added to the
+// request context
+func Auth(tokenUtils verify.TokenUtils, db storage.DBRepo) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			tokenString := r.Header.Get("Authorization")
-			if tokenString == "" {
-				helpers.WriteJSON(w, http.StatusUnauthorized, helpers.ErrorResponse("authorization failed"))
+			tokenString, ok := BearerToken(r)
+			if !ok {
+				// browsers that signed in using a session cookie don't send a bearer token
+				sessionCookie, err := r.Cookie(SessionCookieName)
+				if err != nil || r.Header.Get("Authorization") != "" {
+					helpers.WriteJSON(w, http.StatusUnauthorized, helpers.ErrorResponse("authorization failed"))
+					return
+				}
+
+				claims, ok := sessionClaims(w, r, db, sessionCookie)
+				if !ok {
+					return
+				}
+
+				setLogUserID(r.Context(), claims.Subject)
+
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey, claims)))
 				return
 			}
 
-			tokenParts := strings.Split(tokenString, " ")
-			if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-				helpers.WriteJSON(w, http.StatusUnauthorized, helpers.ErrorResponse("authorization failed"))
+			claims, err := tokenUtils.ValidateToken(tokenString)
+			if err != nil {
+				helpers.WriteJSON(w, http.StatusUnauthorized, helpers.ErrorResponse("token verification failed"))
 				return
 			}
 
-			authorized := false
-			for _, secret := range secrets {
-				if authorized {
-					break
-				}
-
-				tokenUtils := verify.JWTTokenUtils{}
-				tokenUtils.Setup(secret)
-				err := tokenUtils.ValidateToken(tokenParts[1])
-				if err != nil {
-					continue
-				}
-
-				authorized = true
+			err = CheckTokenRevoked(r.Context(), db, claims)
+			if errors.Is(err, ErrTokenRevoked) {
+				helpers.WriteJSON(w, http.StatusUnauthorized, helpers.ErrorResponse(err.Error()))
+				return
 			}
 
-			if !authorized {
-				helpers.WriteJSON(w, http.StatusUnauthorized, helpers.ErrorResponse("token verification failed"))
+			if err != nil {
+				helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse("token verification failed"))
 				return
 			}
 
-			next.ServeHTTP(w, r)
+			setLogUserID(r.Context(), claims.Subject)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey, claims)))
 		})
 	}
 }
+
+// CheckTokenRevoked returns ErrTokenRevoked if a validated token has been revoked. Tokens that are accepted outside
+// of Auth (e.g. the subject token of a token exchange) must be checked as well
+func CheckTokenRevoked(ctx context.Context, db storage.DBRepo, claims *verify.Claims) error {
+	// tokens without a jti claim can't be revoked
+	if claims.ID != "" {
+		revoked, err := db.IsTokenRevoked(ctx, claims.ID)
+		if err != nil {
+			return err
+		}
+
+		if revoked {
+			return ErrTokenRevoked
+		}
+	}
+
+	// all tokens of a user are revoked when the token version of the user is increased or the user is deleted
+	if claims.IsUserToken() {
+		user, err := db.GetUserByID(ctx, claims.Subject)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrTokenRevoked
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if claims.TokenVersion < user.TokenVersion {
+			return ErrTokenRevoked
+		}
+	}
+
+	return nil
+}
+
+// ClaimsFromContext returns the claims of the token validated by the Auth middleware
+func ClaimsFromContext(ctx context.Context) (*verify.Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*verify.Claims)
+	return claims, ok
+}
+
+// BearerToken returns the token of a "Authorization: Bearer <token>" header
+func BearerToken(r *http.Request) (string, bool) {
+	tokenString := r.Header.Get("Authorization")
+	if tokenString == "" {
+		return "", false
+	}
+
+	tokenParts := strings.Split(tokenString, " ")
+	if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+		return "", false
+	}
+
+	return tokenParts[1], true
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies are the reverse proxies that are allowed to set the X-Forwarded-For header
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies parses a comma separated list of IP addresses and CIDR ranges
+func ParseTrustedProxies(value string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, proxy := range strings.Split(value, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if !strings.Contains(proxy, "/") {
+			addr, err := netip.ParseAddr(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+			}
+
+			proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+
+		proxies = append(proxies, prefix.Masked())
+	}
+
+	return proxies, nil
+}
+
+func (p TrustedProxies) contains(addr netip.Addr) bool {
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClientIP returns the IP address of the client. X-Forwarded-For is only used when the request was sent by a trusted
+// proxy. The header is read from right to left, because the addresses on the left can be set by the client
+func (p TrustedProxies) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	remoteAddr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+
+	clientIP := remoteAddr.Unmap()
+	if !p.contains(clientIP) {
+		return clientIP.String()
+	}
+
+	forwardedFor := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwardedFor[i]))
+		if err != nil {
+			break
+		}
+
+		clientIP = addr.Unmap()
+		if !p.contains(clientIP) {
+			break
+		}
+	}
+
+	return clientIP.String()
+}
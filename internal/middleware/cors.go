@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsExposedHeaders are the response headers browsers let javascript on other origins read
+var corsExposedHeaders = []string{RequestIDHeader, IdempotentReplayedHeader, "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "WWW-Authenticate"}
+
+// CORSConfig configures which browser clients on other origins can call the api
+type CORSConfig struct {
+	// AllowedOrigins are the origins (e.g. https://app.example.com) that can call the api. "*" allows any origin, but
+	// can't be combined with AllowCredentials. No origin is allowed when it is empty
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is how long browsers cache the response to a preflight request
+	MaxAge time.Duration
+}
+
+// ParseList splits a comma separated list and drops empty items
+func ParseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func (c CORSConfig) allowOrigin(origin string) (string, bool) {
+	if slices.Contains(c.AllowedOrigins, origin) {
+		return origin, true
+	}
+
+	if slices.Contains(c.AllowedOrigins, "*") {
+		return "*", true
+	}
+
+	return "", false
+}
+
+func (c CORSConfig) allowPreflight(r *http.Request) bool {
+	if !slices.Contains(c.AllowedMethods, r.Header.Get("Access-Control-Request-Method")) {
+		return false
+	}
+
+	for _, header := range ParseList(strings.Join(r.Header.Values("Access-Control-Request-Headers"), ",")) {
+		if !slices.ContainsFunc(c.AllowedHeaders, func(allowed string) bool { return strings.EqualFold(allowed, header) }) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CORS adds the CORS headers for allowed origins. Preflight requests are answered here, so they never reach the
+// routes and their authentication. Requests from other origins get no CORS headers, which makes browsers block them
+func CORS(config CORSConfig) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			isPreflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			// responses depend on the origin, so caches must not share them between origins
+			w.Header().Add("Vary", "Origin")
+			if isPreflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+			}
+
+			allowedOrigin, ok := config.allowOrigin(origin)
+			if origin == "" || !ok {
+				if isPreflight {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if isPreflight {
+				if config.allowPreflight(r) {
+					setCORSOriginHeaders(w, config, allowedOrigin)
+					w.Header().Set("Access-Control-Allow-Methods", strings.Join(config.AllowedMethods, ", "))
+					w.Header().Set("Access-Control-Allow-Headers", strings.Join(config.AllowedHeaders, ", "))
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(config.MaxAge.Seconds())))
+				}
+
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			setCORSOriginHeaders(w, config, allowedOrigin)
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func setCORSOriginHeaders(w http.ResponseWriter, config CORSConfig, allowedOrigin string) {
+	w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
+	if config.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
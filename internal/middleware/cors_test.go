@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS(t *testing.T) {
+	config := CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+
+	tests := []struct {
+		desc           string
+		config         CORSConfig
+		method         string
+		headers        map[string]string
+		status         int
+		want           map[string]string
+		reachedHandler bool
+	}{
+		{desc: "same origin request", config: config, method: http.MethodGet, status: http.StatusOK, want: map[string]string{"Access-Control-Allow-Origin": ""}, reachedHandler: true},
+		{desc: "allowed origin", config: config, method: http.MethodGet, headers: map[string]string{"Origin": "https://app.example.com"}, status: http.StatusOK, want: map[string]string{"Access-Control-Allow-Origin": "https://app.example.com", "Access-Control-Allow-Credentials": "true", "Access-Control-Expose-Headers": "X-Request-ID, Idempotent-Replayed, Retry-After, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, WWW-Authenticate", "Vary": "Origin"}, reachedHandler: true},
+		{desc: "other origin", config: config, method: http.MethodGet, headers: map[string]string{"Origin": "https://evil.example.com"}, status: http.StatusOK, want: map[string]string{"Access-Control-Allow-Origin": ""}, reachedHandler: true},
+		{desc: "any origin", config: CORSConfig{AllowedOrigins: []string{"*"}}, method: http.MethodGet, headers: map[string]string{"Origin": "https://other.example.com"}, status: http.StatusOK, want: map[string]string{"Access-Control-Allow-Origin": "*", "Access-Control-Allow-Credentials": ""}, reachedHandler: true},
+		{desc: "preflight", config: config, method: http.MethodOptions, headers: map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "authorization, content-type"}, status: http.StatusNoContent, want: map[string]string{"Access-Control-Allow-Origin": "https://app.example.com", "Access-Control-Allow-Methods": "GET, POST", "Access-Control-Allow-Headers": "Authorization, Content-Type", "Access-Control-Max-Age": "600", "Access-Control-Allow-Credentials": "true"}},
+		{desc: "preflight with method not allowed", config: config, method: http.MethodOptions, headers: map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "DELETE"}, status: http.StatusNoContent, want: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Methods": ""}},
+		{desc: "preflight with header not allowed", config: config, method: http.MethodOptions, headers: map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "X-Custom"}, status: http.StatusNoContent, want: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{desc: "preflight from other origin", config: config, method: http.MethodOptions, headers: map[string]string{"Origin": "https://evil.example.com", "Access-Control-Request-Method": "POST"}, status: http.StatusNoContent, want: map[string]string{"Access-Control-Allow-Origin": ""}},
+		{desc: "options without preflight", config: config, method: http.MethodOptions, headers: map[string]string{"Origin": "https://app.example.com"}, status: http.StatusOK, want: map[string]string{"Access-Control-Allow-Origin": "https://app.example.com"}, reachedHandler: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			reachedHandler := false
+			handler := CORS(test.config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reachedHandler = true
+			}))
+
+			req := httptest.NewRequest(test.method, "/v1/userinfo", nil)
+			for key, value := range test.headers {
+				req.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, test.status, w.Code)
+			assert.Equal(t, test.reachedHandler, reachedHandler)
+			for key, value := range test.want {
+				assert.Equal(t, value, w.Header().Get(key), key)
+			}
+		})
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	tests := []struct {
+		desc       string
+		hstsMaxAge time.Duration
+		hsts       string
+	}{
+		{desc: "hsts", hstsMaxAge: 24 * time.Hour, hsts: "max-age=86400; includeSubDomains"},
+		{desc: "hsts disabled", hstsMaxAge: 0, hsts: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			handler := SecurityHeaders(test.hstsMaxAge)(NoStore(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/auth/token", nil))
+
+			assert.Equal(t, test.hsts, w.Header().Get("Strict-Transport-Security"))
+			assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+			assert.Equal(t, ContentSecurityPolicy, w.Header().Get("Content-Security-Policy"))
+			assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		})
+	}
+}
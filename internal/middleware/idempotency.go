@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"auth_api/internal/helpers"
+	"auth_api/internal/models"
+	"auth_api/internal/storage"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// IdempotencyKeyHeader lets clients retry a request without handling it twice
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on responses that were replayed for a retried request
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+	// maxIdempotencyBodyBytes limits the size of request bodies that are hashed
+	maxIdempotencyBodyBytes = 1 << 20
+)
+
+// idempotencyWriter passes the response through to the client and keeps a copy to replay it
+type idempotencyWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *idempotencyWriter) WriteHeader(statusCode int) {
+	w.ResponseWriter.WriteHeader(statusCode)
+	w.statusCode = statusCode
+}
+
+func (w *idempotencyWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Idempotency replays the first response to requests with the same Idempotency-Key header for ttl, so clients can
+// safely retry requests. Requests without the header are handled as usual. Reusing a key for a different request gets
+// a 422 response, and a retry that arrives while the first request is still handled gets a 409 response. Server errors
+// aren't stored, so the request can be retried
+func Idempotency(db storage.DBRepo, ttl time.Duration) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			idempotencyKey := r.Header.Get(IdempotencyKeyHeader)
+			if idempotencyKey == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if len(idempotencyKey) > maxIdempotencyKeyLength {
+				helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("idempotency key is too long"))
+				return
+			}
+
+			requestHash, err := hashRequest(r)
+			if err != nil {
+				helpers.WriteJSON(w, http.StatusBadRequest, helpers.ErrorResponse("unable to read request body"))
+				return
+			}
+
+			// keys of different routes don't conflict
+			key := r.Method + " " + r.URL.Path + " " + idempotencyKey
+			created, err := db.CreateIdempotencyKey(r.Context(), &models.IdempotencyKey{
+				Key:         key,
+				RequestHash: requestHash,
+				ExpiresAt:   time.Now().Add(ttl),
+			})
+			if err != nil {
+				helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse("idempotency check failed"))
+				return
+			}
+
+			if !created {
+				replayResponse(w, r, db, key, requestHash)
+				return
+			}
+
+			wrapped := &idempotencyWriter{
+				ResponseWriter: w,
+				statusCode:     http.StatusOK,
+			}
+
+			saved := false
+			defer func() {
+				// the key is released if the handler failed, so the request can be retried. The request context could
+				// be canceled already
+				if !saved {
+					db.DeleteIdempotencyKey(context.WithoutCancel(r.Context()), key)
+				}
+			}()
+
+			next.ServeHTTP(wrapped, r)
+
+			if wrapped.statusCode >= http.StatusInternalServerError {
+				return
+			}
+
+			if err := db.SaveIdempotencyResponse(context.WithoutCancel(r.Context()), key, wrapped.statusCode, w.Header().Get("Content-Type"), wrapped.body.Bytes()); err == nil {
+				saved = true
+			}
+		})
+	}
+}
+
+// replayResponse writes the stored response of an earlier request with the same idempotency key
+func replayResponse(w http.ResponseWriter, r *http.Request, db storage.DBRepo, key, requestHash string) {
+	stored, err := db.GetIdempotencyKey(r.Context(), key)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the first request failed and released the key in the meantime
+		helpers.WriteJSON(w, http.StatusConflict, helpers.ErrorResponse("request with this idempotency key is in progress, try again later"))
+		return
+	}
+
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse("idempotency check failed"))
+		return
+	}
+
+	if stored.RequestHash != requestHash {
+		helpers.WriteJSON(w, http.StatusUnprocessableEntity, helpers.ErrorResponse("idempotency key was used for a different request"))
+		return
+	}
+
+	if stored.Status == nil {
+		helpers.WriteJSON(w, http.StatusConflict, helpers.ErrorResponse("request with this idempotency key is in progress, try again later"))
+		return
+	}
+
+	if stored.ContentType != "" {
+		w.Header().Set("Content-Type", stored.ContentType)
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(*stored.Status)
+	w.Write(stored.Response)
+}
+
+// hashRequest hashes the body of a request, so a reused key can be detected. The body can still be read by the handler
+func hashRequest(r *http.Request) (string, error) {
+	hash := sha256.New()
+	if r.Body != nil {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotencyBodyBytes))
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		if err != nil {
+			return "", err
+		}
+
+		hash.Write(body)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
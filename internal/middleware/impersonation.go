@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"auth_api/internal/helpers"
+	"net/http"
+)
+
+// NotImpersonated refuses tokens that were issued to an admin impersonating a user. It must run after Auth
+func NotImpersonated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := ClaimsFromContext(r.Context())
+		if ok && claims.IsImpersonated() {
+			helpers.WriteJSON(w, http.StatusForbidden, helpers.ErrorResponse("not allowed while impersonating a user"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,31 +1,191 @@
 package middleware
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
 	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// RequestIDHeader contains the ID of a request. An ID sent by the client (e.g. a load balancer) is kept, otherwise
+// a new ID is generated. The ID is returned in the response
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the size of request IDs sent by clients, since they end up in every log entry
+const maxRequestIDLength = 128
+
+const requestLogContextKey = contextKey("request_log")
+
+// requestLog collects the fields of the log entry of a request. Middlewares further down the chain only see a copy of
+// the request, so they fill in the fields using the pointer in the request context
+type requestLog struct {
+	requestID string
+	route     string
+	userID    string
+}
+
 type wrappedWriter struct {
 	http.ResponseWriter
-	statusCode int
+	statusCode  int
+	bytes       int
+	wroteHeader bool
 }
 
 func (w *wrappedWriter) WriteHeader(statusCode int) {
 	w.ResponseWriter.WriteHeader(statusCode)
 	w.statusCode = statusCode
+	w.wroteHeader = true
+}
+
+func (w *wrappedWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the original response writer
+func (w *wrappedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Logging writes a structured log entry for every request. The request ID is added to the request context, so handlers
+// can include it in their logs using RequestLogger
+func Logging(logger *slog.Logger, proxies TrustedProxies) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			requestID := r.Header.Get(RequestIDHeader)
+			if !validRequestID(requestID) {
+				requestID = uuid.NewString()
+			}
+			w.Header().Set(RequestIDHeader, requestID)
+
+			entry := &requestLog{requestID: requestID}
+			wrapped := &wrappedWriter{
+				ResponseWriter: w,
+				statusCode:     http.StatusOK,
+			}
+
+			next.ServeHTTP(wrapped, r.WithContext(context.WithValue(r.Context(), requestLogContextKey, entry)))
+
+			level := slog.LevelInfo
+			if wrapped.statusCode >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+
+			attrs := []slog.Attr{
+				slog.String("request_id", requestID),
+				slog.String("method", r.Method),
+				slog.String("route", entry.route),
+				slog.Int("status", wrapped.statusCode),
+				slog.Int("bytes", wrapped.bytes),
+				slog.Duration("latency", time.Since(start)),
+				slog.String("client_ip", proxies.ClientIP(r)),
+			}
+			if entry.userID != "" {
+				attrs = append(attrs, slog.String("user_id", entry.userID))
+			}
+			if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.IsValid() {
+				attrs = append(attrs, slog.String("trace_id", spanContext.TraceID().String()))
+			}
+
+			logger.LogAttrs(r.Context(), level, "request", attrs...)
+		})
+	}
+}
+
+// Route records the route pattern that matched the request, so requests are grouped by route instead of by path. The
+// span of the request is named after the route
+func Route(pattern string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if entry, ok := r.Context().Value(requestLogContextKey).(*requestLog); ok {
+				entry.route = pattern
+			}
+
+			span := trace.SpanFromContext(r.Context())
+			span.SetName(r.Method + " " + pattern)
+			span.SetAttributes(attribute.String("http.route", pattern))
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequestID returns the ID of the request added by the Logging middleware
+func RequestID(ctx context.Context) string {
+	if entry, ok := ctx.Value(requestLogContextKey).(*requestLog); ok {
+		return entry.requestID
+	}
+
+	return ""
 }
 
-func Logging(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
+// RequestLogger returns a logger that adds the request ID to every log entry
+func RequestLogger(ctx context.Context, logger *slog.Logger) *slog.Logger {
+	if requestID := RequestID(ctx); requestID != "" {
+		return logger.With("request_id", requestID)
+	}
+
+	return logger
+}
+
+// setLogUserID records the authenticated user of the request
+func setLogUserID(ctx context.Context, userID string) {
+	if entry, ok := ctx.Value(requestLogContextKey).(*requestLog); ok {
+		entry.userID = userID
+	}
+}
 
-		wrapped := &wrappedWriter{
-			ResponseWriter: w,
-			statusCode:     http.StatusOK,
+// validRequestID only accepts short IDs of letters, digits and a few separators, so clients can't inject arbitrary
+// text into the logs
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range requestID {
+		isAlphanumeric := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !isAlphanumeric && c != '-' && c != '_' && c != '.' && c != ':' {
+			return false
 		}
+	}
+
+	return true
+}
+
+// RequestObserver records metrics of HTTP requests
+type RequestObserver interface {
+	ObserveRequest(method, route string, status int, duration time.Duration)
+}
+
+// Metrics records the method, route, status and duration of requests. It must run after the Logging middleware, which
+// provides the route. Requests that don't match a route are recorded with the route "unmatched", so unknown paths
+// don't create new metrics
+func Metrics(observer RequestObserver) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			wrapped := &wrappedWriter{
+				ResponseWriter: w,
+				statusCode:     http.StatusOK,
+			}
+
+			next.ServeHTTP(wrapped, r)
+
+			route := "unmatched"
+			if entry, ok := r.Context().Value(requestLogContextKey).(*requestLog); ok && entry.route != "" {
+				route = entry.route
+			}
 
-		next.ServeHTTP(wrapped, r)
-		log.Println(wrapped.statusCode, r.Method, r.URL.Path, time.Since(start))
-	})
+			observer.ObserveRequest(r.Method, route, wrapped.statusCode, time.Since(start))
+		})
+	}
 }
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogging(t *testing.T) {
+	tests := []struct {
+		desc          string
+		requestID     string
+		userID        string
+		wantRequestID string
+	}{
+		{desc: "incoming request id", requestID: "abc-123", wantRequestID: "abc-123"},
+		{desc: "generated request id", requestID: ""},
+		{desc: "invalid request id", requestID: "abc 123\n"},
+		{desc: "authenticated user", requestID: "abc-123", userID: "1234567890", wantRequestID: "abc-123"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var logs bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+			var handlerRequestID string
+			handler := Logging(logger, nil)(Route("/v1/userinfo")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerRequestID = RequestID(r.Context())
+				if test.userID != "" {
+					setLogUserID(r.Context(), test.userID)
+				}
+
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte("hello"))
+			})))
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/userinfo", nil)
+			req.RemoteAddr = "203.0.113.7:1234"
+			if test.requestID != "" {
+				req.Header.Set(RequestIDHeader, test.requestID)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			requestID := w.Result().Header.Get(RequestIDHeader)
+			if test.wantRequestID != "" {
+				assert.Equal(t, test.wantRequestID, requestID)
+			} else {
+				assert.NotEqual(t, test.requestID, requestID)
+				assert.True(t, validRequestID(requestID))
+			}
+			assert.Equal(t, requestID, handlerRequestID)
+
+			var entry map[string]any
+			assert.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+			assert.Equal(t, requestID, entry["request_id"])
+			assert.Equal(t, http.MethodGet, entry["method"])
+			assert.Equal(t, "/v1/userinfo", entry["route"])
+			assert.Equal(t, float64(http.StatusCreated), entry["status"])
+			assert.Equal(t, float64(5), entry["bytes"])
+			assert.Equal(t, "203.0.113.7", entry["client_ip"])
+			assert.Contains(t, entry, "latency")
+			if test.userID != "" {
+				assert.Equal(t, test.userID, entry["user_id"])
+			} else {
+				assert.NotContains(t, entry, "user_id")
+			}
+		})
+	}
+}
@@ -1,17 +1,126 @@
 package middleware
 
 import (
+	"auth_api/internal/helpers"
+	"auth_api/internal/ratelimit"
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func RateLimiter(next http.Handler) http.Handler {
-	// limiter := rate.NewLimiter(2, 4)
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// if !limiter.Allow() {
-		// 	helpers.WriteJSON(w, http.StatusTooManyRequests, helpers.ErrorResponse("The API is at capacity, try again later."))
-		// 	return
-		// }
+const maxRateLimitBodyBytes = 1048576
 
-		next.ServeHTTP(w, r)
-	})
+// RateLimitKey returns the rate limit keys of a request. Every key has its own token bucket
+type RateLimitKey func(r *http.Request) []string
+
+// IPKey keys requests by the IP address of the client
+func IPKey(proxies TrustedProxies) RateLimitKey {
+	return func(r *http.Request) []string {
+		return []string{"ip:" + proxies.ClientIP(r)}
+	}
+}
+
+// EmailKey keys requests by the email in the JSON or form body, so an account can't be targeted from many IP
+// addresses. The body can still be read by the handler
+func EmailKey(r *http.Request) []string {
+	if r.Body == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRateLimitBodyBytes))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil {
+		return nil
+	}
+
+	var email string
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/x-www-form-urlencoded" {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil
+		}
+
+		email = values.Get("email")
+	} else {
+		var requestBody struct {
+			Email string `json:"email"`
+		}
+
+		if err := json.Unmarshal(body, &requestBody); err != nil {
+			return nil
+		}
+
+		email = requestBody.Email
+	}
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" {
+		return nil
+	}
+
+	return []string{"email:" + email}
+}
+
+// RateLimit limits requests using a token bucket per key. The buckets of a route class are separated using the class
+// name. The RateLimit headers describe the most restrictive bucket
+func RateLimit(store ratelimit.Store, class string, limit ratelimit.Limit, keys ...RateLimitKey) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var result *ratelimit.Result
+			for _, key := range keys {
+				for _, k := range key(r) {
+					keyResult, err := store.Take(r.Context(), class+":"+k, limit)
+					if err != nil {
+						helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse("rate limit check failed"))
+						return
+					}
+
+					if result == nil || moreRestrictive(keyResult, *result) {
+						result = &keyResult
+					}
+				}
+			}
+
+			if result == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				helpers.WriteJSON(w, http.StatusTooManyRequests, helpers.ErrorResponse("too many requests, try again later"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func moreRestrictive(a, b ratelimit.Result) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+
+	if !a.Allowed {
+		return a.RetryAfter > b.RetryAfter
+	}
+
+	return a.Remaining < b.Remaining
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
 }
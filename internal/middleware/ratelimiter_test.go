@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"auth_api/internal/ratelimit"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		desc         string
+		remoteAddr   string
+		forwardedFor string
+		want         string
+	}{
+		{desc: "direct request", remoteAddr: "203.0.113.7:1234", want: "203.0.113.7"},
+		{desc: "untrusted proxy", remoteAddr: "203.0.113.7:1234", forwardedFor: "198.51.100.1", want: "203.0.113.7"},
+		{desc: "trusted proxy", remoteAddr: "10.1.2.3:1234", forwardedFor: "198.51.100.1", want: "198.51.100.1"},
+		{desc: "spoofed header", remoteAddr: "10.1.2.3:1234", forwardedFor: "1.1.1.1, 198.51.100.1", want: "198.51.100.1"},
+		{desc: "chain of trusted proxies", remoteAddr: "10.1.2.3:1234", forwardedFor: "198.51.100.1, 192.168.1.1", want: "198.51.100.1"},
+		{desc: "only trusted proxies", remoteAddr: "10.1.2.3:1234", forwardedFor: "10.0.0.1", want: "10.0.0.1"},
+		{desc: "invalid header", remoteAddr: "10.1.2.3:1234", forwardedFor: "unknown", want: "10.1.2.3"},
+		{desc: "ipv6", remoteAddr: "[2001:db8::1]:1234", want: "2001:db8::1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = test.remoteAddr
+			if test.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", test.forwardedFor)
+			}
+
+			assert.Equal(t, test.want, proxies.ClientIP(req))
+		})
+	}
+
+	_, err = ParseTrustedProxies("10.0.0.0/33")
+	assert.Error(t, err)
+}
+
+func TestRateLimit(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	limit := ratelimit.Limit{Requests: 2, Period: time.Minute}
+	handler := RateLimit(store, "account", limit, IPKey(nil), EmailKey)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the handler can still read the body
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+
+	tests := []struct {
+		desc        string
+		remoteAddr  string
+		contentType string
+		reqBody     string
+		status      int
+		remaining   string
+		retryAfter  string
+	}{
+		{desc: "first request", remoteAddr: "203.0.113.7:1234", reqBody: `{"email": "verified@gmail.com"}`, status: http.StatusOK, remaining: "1"},
+		{desc: "same email using a form", remoteAddr: "203.0.113.8:1234", contentType: "application/x-www-form-urlencoded", reqBody: "email=Verified%40gmail.com", status: http.StatusOK, remaining: "0"},
+		{desc: "email limited from another ip", remoteAddr: "203.0.113.9:1234", reqBody: `{"email": "verified@gmail.com"}`, status: http.StatusTooManyRequests, remaining: "0", retryAfter: "30"},
+		{desc: "other email", remoteAddr: "203.0.113.7:1234", reqBody: `{"email": "other@gmail.com"}`, status: http.StatusOK, remaining: "0"},
+		{desc: "ip limited", remoteAddr: "203.0.113.7:1234", reqBody: `{"email": "another@gmail.com"}`, status: http.StatusTooManyRequests, remaining: "0", retryAfter: "30"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/auth/token", strings.NewReader(test.reqBody))
+			req.RemoteAddr = test.remoteAddr
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			resp := w.Result()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Errorf("didn't expect error but got %s", err)
+			}
+
+			assert.Equal(t, test.status, resp.StatusCode)
+			assert.Equal(t, "2", resp.Header.Get("RateLimit-Limit"))
+			assert.Equal(t, test.remaining, resp.Header.Get("RateLimit-Remaining"))
+			assert.Equal(t, test.retryAfter, resp.Header.Get("Retry-After"))
+			if test.status == http.StatusOK {
+				assert.Equal(t, test.reqBody, string(body))
+			} else {
+				assert.Equal(t, `{"status":"error","message":"too many requests, try again later"}`, string(body))
+			}
+		})
+	}
+}
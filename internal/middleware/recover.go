@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"auth_api/internal/helpers"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// PanicObserver counts the panics recovered from
+type PanicObserver interface {
+	PanicRecovered()
+}
+
+// Recover responds with a 500 error instead of dropping the connection when a handler panics. The panic is logged with
+// its stack trace and the request ID, so it must run after the Logging middleware
+func Recover(logger *slog.Logger, observer PanicObserver) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wrapped := &wrappedWriter{
+				ResponseWriter: w,
+				statusCode:     http.StatusOK,
+			}
+
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+
+				// http.ErrAbortHandler aborts the response on purpose
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+
+				observer.PanicRecovered()
+				RequestLogger(r.Context(), logger).Error("panic recovered", "panic", fmt.Sprint(rec), "stack", string(debug.Stack()))
+
+				// the client already received a part of the response, so the status can't be changed anymore
+				if wrapped.wroteHeader {
+					return
+				}
+
+				helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse("internal server error"))
+			}()
+
+			next.ServeHTTP(wrapped, r)
+		})
+	}
+}
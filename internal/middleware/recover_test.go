@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testPanicObserver struct {
+	panics int
+}
+
+func (o *testPanicObserver) PanicRecovered() {
+	o.panics++
+}
+
+func TestRecover(t *testing.T) {
+	tests := []struct {
+		desc    string
+		handler http.HandlerFunc
+		status  int
+		want    string
+		panics  int
+	}{
+		{desc: "no panic", handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }, status: http.StatusOK, want: "ok"},
+		{desc: "panic", handler: func(w http.ResponseWriter, r *http.Request) { panic("boom") }, status: http.StatusInternalServerError, want: `{"status":"error","message":"internal server error"}`, panics: 1},
+		{desc: "panic after response started", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			panic("boom")
+		}, status: http.StatusAccepted, want: "", panics: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var logs bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&logs, nil))
+			observer := &testPanicObserver{}
+
+			handler := Logging(slog.New(slog.NewJSONHandler(&bytes.Buffer{}, nil)), nil)(Recover(logger, observer)(test.handler))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(RequestIDHeader, "abc-123")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, test.status, w.Code)
+			assert.Equal(t, test.want, w.Body.String())
+			assert.Equal(t, test.panics, observer.panics)
+			if test.panics > 0 {
+				assert.Contains(t, logs.String(), `"msg":"panic recovered","request_id":"abc-123","panic":"boom","stack":"`)
+				assert.Contains(t, logs.String(), "recover_test.go")
+			} else {
+				assert.Empty(t, logs.String())
+			}
+		})
+	}
+
+	t.Run("abort handler", func(t *testing.T) {
+		handler := Recover(slog.New(slog.NewJSONHandler(&bytes.Buffer{}, nil)), &testPanicObserver{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}))
+
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		})
+	})
+}
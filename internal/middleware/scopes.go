@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"auth_api/internal/helpers"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// RequireScopes refuses tokens that were not granted all the scopes. The WWW-Authenticate header contains an
+// insufficient_scope error (RFC 6750 section 3.1). It must run after Auth
+func RequireScopes(scopes ...string) func(next http.Handler) http.Handler {
+	challenge := fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(scopes, " "))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok || !claims.HasScopes(scopes...) {
+				w.Header().Set("WWW-Authenticate", challenge)
+				helpers.WriteJSON(w, http.StatusForbidden, helpers.ErrorResponse("insufficient scope"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ContentSecurityPolicy is the policy of api responses. Responses are JSON, so nothing may be loaded, embedded or
+// submitted. Pages (e.g. the authorize page) set their own policy
+const ContentSecurityPolicy = "default-src 'none'; frame-ancestors 'none'; base-uri 'none'; form-action 'none'"
+
+// SecurityHeaders adds hardening headers to every response. HSTS tells browsers to only use HTTPS for hstsMaxAge.
+// HSTS isn't sent when hstsMaxAge is zero
+func SecurityHeaders(hstsMaxAge time.Duration) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if hstsMaxAge > 0 {
+				w.Header().Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(hstsMaxAge.Seconds()))+"; includeSubDomains")
+			}
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			w.Header().Set("Content-Security-Policy", ContentSecurityPolicy)
+			w.Header().Set("Referrer-Policy", "no-referrer")
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// NoStore stops browsers and proxies from caching responses that contain tokens
+func NoStore(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Pragma", "no-cache")
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"auth_api/internal/helpers"
+	"auth_api/internal/models"
+	"auth_api/internal/storage"
+	"auth_api/internal/verify"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const (
+	// SessionCookieName is the HttpOnly cookie that contains the session token
+	SessionCookieName = "auth_session"
+	// CSRFCookieName is the cookie that contains the CSRF token. It can be read by javascript, so the token can be
+	// sent back in the CSRFHeaderName header (double submit cookie)
+	CSRFCookieName = "auth_csrf"
+	CSRFHeaderName = "X-CSRF-Token"
+)
+
+// sessionClaims authenticates a request using the session cookie. Requests that change state must send the CSRF token
+// of the session in the CSRF header and cookie. A response is written if authentication fails
+func sessionClaims(w http.ResponseWriter, r *http.Request, db storage.DBRepo, sessionCookie *http.Cookie) (*verify.Claims, bool) {
+	session, err := db.GetSession(r.Context(), verify.HashOpaqueToken(sessionCookie.Value))
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.WriteJSON(w, http.StatusUnauthorized, helpers.ErrorResponse("authorization failed"))
+		return nil, false
+	}
+
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse("session verification failed"))
+		return nil, false
+	}
+
+	if session.ExpiresAt.Before(time.Now()) {
+		helpers.WriteJSON(w, http.StatusUnauthorized, helpers.ErrorResponse("session has expired"))
+		return nil, false
+	}
+
+	if !isSafeMethod(r.Method) && !validCSRFToken(r, session.CSRFTokenHash) {
+		helpers.WriteJSON(w, http.StatusForbidden, helpers.ErrorResponse("invalid csrf token"))
+		return nil, false
+	}
+
+	user, err := db.GetUserByID(r.Context(), session.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.WriteJSON(w, http.StatusUnauthorized, helpers.ErrorResponse("authorization failed"))
+		return nil, false
+	}
+
+	if err != nil {
+		helpers.WriteJSON(w, http.StatusInternalServerError, helpers.ErrorResponse("session verification failed"))
+		return nil, false
+	}
+
+	// sessions end when the user is no longer active, e.g. while resetting their password
+	if user.Status != models.UserStatusActive {
+		helpers.WriteJSON(w, http.StatusUnauthorized, helpers.ErrorResponse("user not active"))
+		return nil, false
+	}
+
+	claims := &verify.Claims{
+		Subject:   user.UserID,
+		ExpiresAt: session.ExpiresAt,
+		Email:     user.Email,
+		Role:      user.Role,
+		Scopes:    user.Scopes(),
+		Custom:    map[string]any{"sid": session.SessionID},
+	}
+
+	return claims, true
+}
+
+// validCSRFToken checks that the CSRF header matches the CSRF cookie and the CSRF token of the session
+func validCSRFToken(r *http.Request, csrfTokenHash string) bool {
+	csrfToken := r.Header.Get(CSRFHeaderName)
+	csrfCookie, err := r.Cookie(CSRFCookieName)
+	if csrfToken == "" || err != nil || csrfCookie.Value != csrfToken {
+		return false
+	}
+
+	return verify.CompareOpaqueToken(csrfTokenHash, csrfToken)
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
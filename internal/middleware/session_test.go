@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"auth_api/internal/models"
+	"auth_api/internal/storage"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testSessionDB returns a single session and the user of the session
+type testSessionDB struct {
+	storage.DBRepo
+	user *models.User
+}
+
+func (db testSessionDB) GetSession(ctx context.Context, tokenHash string) (*models.Session, error) {
+	return &models.Session{SessionID: "c6a1f0de-2b7c-4f4e-9d0a-5e8b1c2d3e01", UserID: "74a8ebde-489d-4c04-843b-8f22f19bae0b", ExpiresAt: time.Now().Add(time.Hour)}, nil
+}
+
+func (db testSessionDB) GetUserByID(ctx context.Context, userID string) (*models.User, error) {
+	if db.user == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	return db.user, nil
+}
+
+func TestSessionAuth(t *testing.T) {
+	tests := []struct {
+		desc   string
+		user   *models.User
+		status int
+		want   string
+	}{
+		{desc: "deleted user", status: http.StatusUnauthorized, want: `{"status":"error","message":"authorization failed"}`},
+		{desc: "user not active", user: &models.User{UserID: "74a8ebde-489d-4c04-843b-8f22f19bae0b", Status: models.UserStatusVerifyResetPassword}, status: http.StatusUnauthorized, want: `{"status":"error","message":"user not active"}`},
+		{desc: "active user", user: &models.User{UserID: "74a8ebde-489d-4c04-843b-8f22f19bae0b", Status: models.UserStatusActive}, status: http.StatusOK, want: "74a8ebde-489d-4c04-843b-8f22f19bae0b"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			handler := Auth(nil, testSessionDB{user: test.user})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				claims, _ := ClaimsFromContext(r.Context())
+				w.Write([]byte(claims.Subject))
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: "validsessiontoken"})
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, test.status, w.Code)
+			assert.Equal(t, test.want, w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// AuthorizationCode is issued to a client after a user approved its authorization request. RedirectURI is the
+// redirect_uri parameter of the authorization request and is empty if the client did not send one. AuthTime is when
+// the user signed in and Nonce is the OpenID Connect nonce of the request
+type AuthorizationCode struct {
+	CodeHash      string     `db:"code_hash"`
+	ClientID      string     `db:"client_id"`
+	UserID        string     `db:"user_id"`
+	RedirectURI   string     `db:"redirect_uri"`
+	Scope         string     `db:"scope"`
+	CodeChallenge string     `db:"code_challenge"`
+	Nonce         string     `db:"nonce"`
+	AuthTime      time.Time  `db:"auth_time"`
+	ExpiresAt     time.Time  `db:"expires_at"`
+	UsedAt        *time.Time `db:"used_at"`
+	CreatedAt     time.Time  `db:"created_at"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// IdempotencyKey is the first response to a request with an Idempotency-Key header. Status is nil while the first
+// request is still being handled
+type IdempotencyKey struct {
+	Key         string    `db:"key"`
+	RequestHash string    `db:"request_hash"`
+	Status      *int      `db:"status"`
+	ContentType string    `db:"content_type"`
+	Response    []byte    `db:"response"`
+	ExpiresAt   time.Time `db:"expires_at"`
+	CreatedAt   time.Time `db:"created_at"`
+}
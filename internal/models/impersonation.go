@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Impersonation is an audit record of an admin that exchanged their token for a token of a user. Records are kept
+// when the user is deleted
+type Impersonation struct {
+	ImpersonationID string `db:"impersonation_id"`
+	// Actor is the subject of the admin token
+	Actor     string    `db:"actor"`
+	UserID    string    `db:"user_id"`
+	TokenID   string    `db:"token_id"`
+	ExpiresAt time.Time `db:"expires_at"`
+	CreatedAt time.Time `db:"created_at"`
+}
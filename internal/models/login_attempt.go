@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// LoginAttempt counts the failed sign in attempts of an email address or a client IP. The key is prefixed with
+// "email:" or "ip:"
+type LoginAttempt struct {
+	Key           string     `db:"key"`
+	Failures      int        `db:"failures"`
+	LockedUntil   *time.Time `db:"locked_until"`
+	LastFailureAt time.Time  `db:"last_failure_at"`
+}
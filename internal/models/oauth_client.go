@@ -0,0 +1,47 @@
+package models
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// OAuthClient is a registered client that can request tokens on its own behalf (e.g. a backend job) or on behalf of
+// a user (e.g. a SPA or mobile app). Scopes and RedirectURIs are space separated lists. Public clients can't keep a
+// secret and don't have a ClientSecretHash
+type OAuthClient struct {
+	ClientID         string    `db:"client_id"`
+	ClientSecretHash string    `db:"client_secret_hash"`
+	Name             string    `db:"name"`
+	Scopes           string    `db:"scopes"`
+	RedirectURIs     string    `db:"redirect_uris"`
+	CreatedAt        time.Time `db:"created_at"`
+	UpdatedAt        time.Time `db:"updated_at"`
+}
+
+// ScopeList returns the allowed scopes of the client
+func (c OAuthClient) ScopeList() []string {
+	return strings.Fields(c.Scopes)
+}
+
+// AllowsScopes returns true if the client may request all the scopes
+func (c OAuthClient) AllowsScopes(scopes []string) bool {
+	allowedScopes := c.ScopeList()
+	for _, scope := range scopes {
+		if !slices.Contains(allowedScopes, scope) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsPublic returns true if the client does not have a secret
+func (c OAuthClient) IsPublic() bool {
+	return c.ClientSecretHash == ""
+}
+
+// RedirectURIList returns the registered redirect uris of the client
+func (c OAuthClient) RedirectURIList() []string {
+	return strings.Fields(c.RedirectURIs)
+}
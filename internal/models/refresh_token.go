@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+type RefreshToken struct {
+	TokenID    string     `db:"token_id"`
+	FamilyID   string     `db:"family_id"`
+	UserID     string     `db:"user_id"`
+	TokenHash  string     `db:"token_hash"`
+	ExpiresAt  time.Time  `db:"expires_at"`
+	RevokedAt  *time.Time `db:"revoked_at"`
+	ReplacedBy *string    `db:"replaced_by"`
+	CreatedAt  time.Time  `db:"created_at"`
+}
+
+// IsRevoked returns true if the token was rotated or its family was revoked
+func (t RefreshToken) IsRevoked() bool {
+	return t.RevokedAt != nil
+}
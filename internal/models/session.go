@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Session is a server side session of a user that signed in using a cookie. Only the hashes of the session and CSRF
+// tokens are stored
+type Session struct {
+	SessionID     string    `db:"session_id"`
+	TokenHash     string    `db:"token_hash"`
+	CSRFTokenHash string    `db:"csrf_token_hash"`
+	UserID        string    `db:"user_id"`
+	ExpiresAt     time.Time `db:"expires_at"`
+	CreatedAt     time.Time `db:"created_at"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type SigningKey struct {
+	KeyID     string `db:"key_id"`
+	Algorithm string `db:"algorithm"`
+	// EncryptedPrivateKey is the private key encrypted using verify.KeyEncryptor
+	EncryptedPrivateKey string     `db:"encrypted_private_key"`
+	RetiresAt           *time.Time `db:"retires_at"`
+	CreatedAt           time.Time  `db:"created_at"`
+}
@@ -1,23 +1,52 @@
 package models
 
-import "time"
+import (
+	"slices"
+	"time"
+)
 
 const (
 	UserStatusVerifyAccount       = "verify_account"
 	UserStatusVerifyResetPassword = "verify_reset"
 	UserStatusActive              = "active"
+
+	RoleUser  = "USER"
+	RoleAdmin = "ADMIN"
+
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	// ScopeAdmin is required on admin routes. It is only issued to admins that signed in using their password
+	ScopeAdmin = "admin"
 )
 
+// DefaultUserScopes are the scopes of tokens issued to users that signed in using their password
+var DefaultUserScopes = []string{ScopeUsersRead, ScopeUsersWrite}
+
 type User struct {
-	UserID    string    `db:"user_id"`
-	Email     string    `db:"email"`
-	Password  string    `db:"password"`
-	Status    string    `db:"status"`
-	Role      string    `db:"role"`
-	CreatedAt time.Time `db:"created_at"`
-	UpdatedAt time.Time `db:"updated_at"`
+	UserID   string `db:"user_id"`
+	Email    string `db:"email"`
+	Password string `db:"password"`
+	Status   string `db:"status"`
+	Role     string `db:"role"`
+	// TokenVersion is increased to invalidate all tokens issued to the user
+	TokenVersion int       `db:"token_version"`
+	CreatedAt    time.Time `db:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at"`
 }
 
 func (u User) IsVerified() bool {
 	return u.Status != UserStatusVerifyAccount
 }
+
+func (u User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// Scopes returns the scopes of tokens issued to the user when they sign in using their password
+func (u User) Scopes() []string {
+	if u.IsAdmin() {
+		return append(slices.Clone(DefaultUserScopes), ScopeAdmin)
+	}
+
+	return DefaultUserScopes
+}
@@ -0,0 +1,33 @@
+package ratelimit
+
+import (
+	"auth_api/internal/storage"
+	"context"
+	"time"
+)
+
+// DBStore keeps the token buckets in the DB, so replicas of the api share the buckets
+type DBStore struct {
+	db storage.DBRepo
+}
+
+func NewDBStore(db storage.DBRepo) *DBStore {
+	return &DBStore{db: db}
+}
+
+func (s *DBStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	if limit.Requests <= 0 || limit.Period <= 0 {
+		return Result{}, ErrInvalidLimit
+	}
+
+	tokens, allowed, err := s.db.TakeRateLimitToken(ctx, key, float64(limit.Requests), limit.refillRate())
+	if err != nil {
+		return Result{}, err
+	}
+
+	return newResult(limit, allowed, tokens), nil
+}
+
+func (s *DBStore) DeleteIdle(ctx context.Context, idleFor time.Duration) (int64, error) {
+	return s.db.DeleteIdleRateLimits(ctx, idleFor)
+}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// MemoryStore keeps the token buckets in memory. Replicas of the api don't share the buckets
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	if limit.Requests <= 0 || limit.Period <= 0 {
+		return Result{}, ErrInvalidLimit
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Requests), updatedAt: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(limit.Requests), b.tokens+now.Sub(b.updatedAt).Seconds()*limit.refillRate())
+	b.updatedAt = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+
+	return newResult(limit, allowed, b.tokens), nil
+}
+
+func (s *MemoryStore) DeleteIdle(ctx context.Context, idleFor time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := s.now().Add(-idleFor)
+	var deleted int64
+	for key, b := range s.buckets {
+		if b.updatedAt.Before(before) {
+			delete(s.buckets, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
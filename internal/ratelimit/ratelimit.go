@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Requests requests per Period. Unused requests accumulate up to Requests, so a client can send a burst
+// of Requests requests after being idle for Period
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseLimit parses a limit in the <requests>/<period> format, e.g. 10/1m
+func ParseLimit(value string) (Limit, error) {
+	requests, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: expected <requests>/<period>", value)
+	}
+
+	limit := Limit{}
+
+	var err error
+	limit.Requests, err = strconv.Atoi(requests)
+	if err != nil || limit.Requests <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: requests must be a positive number", value)
+	}
+
+	limit.Period, err = time.ParseDuration(period)
+	if err != nil || limit.Period <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: period must be a positive duration", value)
+	}
+
+	return limit, nil
+}
+
+// refillRate is the number of tokens added to a bucket per second
+func (l Limit) refillRate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
+
+// Result is the state of a bucket after taking a token
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long to wait before the next request is allowed. It is zero for allowed requests
+	RetryAfter time.Duration
+	// ResetAfter is how long it takes to refill the bucket
+	ResetAfter time.Duration
+}
+
+// newResult returns the result of taking a token from a bucket that has tokens left afterwards
+func newResult(limit Limit, allowed bool, tokens float64) Result {
+	rate := limit.refillRate()
+	result := Result{
+		Allowed:    allowed,
+		Limit:      limit.Requests,
+		Remaining:  int(math.Floor(tokens)),
+		ResetAfter: seconds((float64(limit.Requests) - tokens) / rate),
+	}
+
+	if !allowed {
+		result.RetryAfter = seconds((1 - tokens) / rate)
+	}
+
+	return result
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
+
+// ErrInvalidLimit is returned when taking a token using a limit without requests or without a period
+var ErrInvalidLimit = errors.New("rate limit requires requests and a period")
+
+// Store keeps the token buckets. Every key has its own bucket
+type Store interface {
+	// Take takes a token from the bucket of the key. The request is not allowed if the bucket is empty
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+	// DeleteIdle removes the buckets that haven't been used for idleFor. Buckets that are idle for longer than the
+	// period of their limit are full again
+	DeleteIdle(ctx context.Context, idleFor time.Duration) (int64, error)
+}
@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		desc    string
+		value   string
+		want    Limit
+		wantErr string
+	}{
+		{desc: "requests per minute", value: "10/1m", want: Limit{Requests: 10, Period: time.Minute}},
+		{desc: "requests per second", value: "5/1s", want: Limit{Requests: 5, Period: time.Second}},
+		{desc: "missing period", value: "10", wantErr: `invalid rate limit "10": expected <requests>/<period>`},
+		{desc: "invalid requests", value: "ten/1m", wantErr: `invalid rate limit "ten/1m": requests must be a positive number`},
+		{desc: "zero requests", value: "0/1m", wantErr: `invalid rate limit "0/1m": requests must be a positive number`},
+		{desc: "invalid period", value: "10/minute", wantErr: `invalid rate limit "10/minute": period must be a positive duration`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			limit, err := ParseLimit(test.value)
+			if test.wantErr != "" {
+				assert.EqualError(t, err, test.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, limit)
+		})
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Date(2024, 7, 23, 13, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	limit := Limit{Requests: 2, Period: time.Minute}
+
+	tests := []struct {
+		desc    string
+		key     string
+		advance time.Duration
+		want    Result
+	}{
+		{desc: "first request", key: "a", want: Result{Allowed: true, Limit: 2, Remaining: 1, ResetAfter: 30 * time.Second}},
+		{desc: "burst", key: "a", want: Result{Allowed: true, Limit: 2, Remaining: 0, ResetAfter: time.Minute}},
+		{desc: "bucket empty", key: "a", advance: 10 * time.Second, want: Result{Allowed: false, Limit: 2, Remaining: 0, RetryAfter: 20 * time.Second, ResetAfter: 50 * time.Second}},
+		{desc: "other key", key: "b", want: Result{Allowed: true, Limit: 2, Remaining: 1, ResetAfter: 30 * time.Second}},
+		{desc: "refilled", key: "a", advance: 20 * time.Second, want: Result{Allowed: true, Limit: 2, Remaining: 0, ResetAfter: time.Minute}},
+		{desc: "full after the period", key: "a", advance: 5 * time.Minute, want: Result{Allowed: true, Limit: 2, Remaining: 1, ResetAfter: 30 * time.Second}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			now = now.Add(test.advance)
+			result, err := store.Take(ctx, test.key, limit)
+			assert.NoError(t, err)
+			assert.Equal(t, test.want.Allowed, result.Allowed)
+			assert.Equal(t, test.want.Limit, result.Limit)
+			assert.Equal(t, test.want.Remaining, result.Remaining)
+			assert.InDelta(t, test.want.RetryAfter, result.RetryAfter, float64(time.Millisecond))
+			assert.InDelta(t, test.want.ResetAfter, result.ResetAfter, float64(time.Millisecond))
+		})
+	}
+
+	_, err := store.Take(ctx, "a", Limit{})
+	assert.ErrorIs(t, err, ErrInvalidLimit)
+
+	// only the bucket of b has been idle for a minute
+	now = now.Add(time.Minute)
+	store.Take(ctx, "a", limit)
+	deleted, err := store.DeleteIdle(ctx, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+}
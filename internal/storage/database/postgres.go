@@ -2,7 +2,9 @@ package database
 
 import (
 	"auth_api/internal/models"
+	"auth_api/internal/storage"
 	"context"
+	"database/sql"
 	"fmt"
 	"time"
 
@@ -10,12 +12,17 @@ import (
 )
 
 const (
-	UserGetSQL = `SELECT user_id, email, password, status, role, created_at, updated_at
+	UserGetSQL = `SELECT user_id, email, password, status, role, token_version, created_at, updated_at
 	FROM users
 	WHERE email = $1`
-	UserCreateSQL = `INSERT INTO users (user_id, email, password, status, role) values ($1::uuid, $2, $3, $4, $5)`
-	UserUpdateSQL = `UPDATE users set email = $1, password = $2, status = $3, role = $4, updated_at = now() WHERE user_id = $5`
-	UserDeleteSQL = `DELETE FROM users where email = $1`
+	UserGetByIDSQL = `SELECT user_id, email, password, status, role, token_version, created_at, updated_at
+	FROM users
+	WHERE user_id = $1`
+	UserCreateSQL                = `INSERT INTO users (user_id, email, password, status, role) values ($1::uuid, $2, $3, $4, $5)`
+	UserUpdateSQL                = `UPDATE users set email = $1, password = $2, status = $3, role = $4, updated_at = now() WHERE user_id = $5`
+	UserDeleteSQL                = `DELETE FROM users where email = $1`
+	UserIncrementTokenVersionSQL = `UPDATE users set token_version = token_version + 1, updated_at = now() WHERE user_id = $1`
+	UserRoleExistsSQL            = `SELECT exists(SELECT 1 FROM users WHERE role = $1)`
 
 	VerificationUpsertSQL = `INSERT INTO verification (email, verification_type, verification_code, expires_at, attempts_remaining) 
 values ($1, $2, $3, $4, $5)
@@ -23,20 +30,157 @@ on conflict (email)
   do update set email = $1, verification_type = $2, verification_code = $3, expires_at = $4, attempts_remaining = $5;`
 	VerificationGetSQL    = `SELECT email, verification_type, verification_code, expires_at, attempts_remaining, created_at, updated_at FROM verification WHERE email = $1 and verification_type = $2`
 	VerificationDeleteSQL = `DELETE FROM verification WHERE email = $1`
+
+	RefreshTokenCreateSQL       = `INSERT INTO refresh_tokens (token_id, family_id, user_id, token_hash, expires_at) values ($1::uuid, $2::uuid, $3::uuid, $4, $5)`
+	RefreshTokenGetSQL          = `SELECT token_id, family_id, user_id, token_hash, expires_at, revoked_at, replaced_by, created_at FROM refresh_tokens WHERE token_hash = $1`
+	RefreshTokenRevokeSQL       = `UPDATE refresh_tokens set revoked_at = now(), replaced_by = $2::uuid WHERE token_id = $1 and revoked_at is null`
+	RefreshTokenRevokeFamilySQL = `UPDATE refresh_tokens set revoked_at = now() WHERE family_id = $1 and revoked_at is null`
+	RefreshTokenRevokeUserSQL   = `UPDATE refresh_tokens set revoked_at = now() WHERE user_id = $1 and revoked_at is null`
+
+	SigningKeyGetAllSQL = `SELECT key_id, algorithm, encrypted_private_key, retires_at, created_at FROM signing_keys ORDER BY created_at, key_id`
+	SigningKeyCreateSQL = `INSERT INTO signing_keys (key_id, algorithm, encrypted_private_key) values ($1, $2, $3)`
+	SigningKeyRetireSQL = `UPDATE signing_keys set retires_at = $1 WHERE retires_at is null`
+
+	RevokedTokenInsertSQL        = `INSERT INTO revoked_tokens (token_id, expires_at) values ($1, $2) on conflict (token_id) do nothing`
+	RevokedTokenExistsSQL        = `SELECT exists(SELECT 1 FROM revoked_tokens WHERE token_id = $1)`
+	RevokedTokenDeleteExpiredSQL = `DELETE FROM revoked_tokens WHERE expires_at < now()`
+
+	OAuthClientCreateSQL = `INSERT INTO oauth_clients (client_id, client_secret_hash, name, scopes, redirect_uris) values ($1::uuid, $2, $3, $4, $5)`
+	OAuthClientGetSQL    = `SELECT client_id, client_secret_hash, name, scopes, redirect_uris, created_at, updated_at FROM oauth_clients WHERE client_id = $1`
+
+	AuthorizationCodeCreateSQL        = `INSERT INTO authorization_codes (code_hash, client_id, user_id, redirect_uri, scope, code_challenge, nonce, auth_time, expires_at) values ($1, $2::uuid, $3::uuid, $4, $5, $6, $7, $8, $9)`
+	AuthorizationCodeConsumeSQL       = `UPDATE authorization_codes set used_at = now() WHERE code_hash = $1 and used_at is null RETURNING code_hash, client_id, user_id, redirect_uri, scope, code_challenge, nonce, auth_time, expires_at, used_at, created_at`
+	AuthorizationCodeDeleteExpiredSQL = `DELETE FROM authorization_codes WHERE expires_at < now()`
+
+	SessionCreateSQL        = `INSERT INTO sessions (session_id, token_hash, csrf_token_hash, user_id, expires_at) values ($1::uuid, $2, $3, $4::uuid, $5)`
+	SessionGetSQL           = `SELECT session_id, token_hash, csrf_token_hash, user_id, expires_at, created_at FROM sessions WHERE token_hash = $1`
+	SessionDeleteSQL        = `DELETE FROM sessions WHERE session_id = $1`
+	SessionDeleteUserSQL    = `DELETE FROM sessions WHERE user_id = $1`
+	SessionDeleteExpiredSQL = `DELETE FROM sessions WHERE expires_at < now()`
+
+	ImpersonationCreateSQL = `INSERT INTO impersonations (impersonation_id, actor, user_id, token_id, expires_at) values ($1::uuid, $2, $3::uuid, $4, $5)`
+
+	// the bucket is refilled for the time since it was last used before a token is taken. Requests that find an empty
+	// bucket don't take a token
+	RateLimitTakeSQL = `INSERT INTO rate_limits (key, tokens, allowed, updated_at) values ($1, $2::float8 - 1, true, now())
+on conflict (key)
+  do update set
+    allowed = least($2::float8, rate_limits.tokens + extract(epoch from now() - rate_limits.updated_at)::float8 * $3::float8) >= 1,
+    tokens = least($2::float8, rate_limits.tokens + extract(epoch from now() - rate_limits.updated_at)::float8 * $3::float8)
+      - case when least($2::float8, rate_limits.tokens + extract(epoch from now() - rate_limits.updated_at)::float8 * $3::float8) >= 1 then 1 else 0 end,
+    updated_at = now()
+RETURNING tokens, allowed`
+	// failed attempts are forgotten when the lock expired or when there were no failed attempts for the lockout duration
+	LoginAttemptGetSQL           = `SELECT key, failures, locked_until, last_failure_at FROM login_attempts WHERE key = $1`
+	LoginAttemptRecordFailureSQL = `INSERT INTO login_attempts (key, failures, locked_until, last_failure_at)
+values ($1, 1, case when 1 >= $2 then now() + make_interval(secs => $3) end, now())
+on conflict (key)
+  do update set
+    failures = case
+      when login_attempts.locked_until <= now() or login_attempts.last_failure_at <= now() - make_interval(secs => $3) then 1
+      else login_attempts.failures + 1
+    end,
+    locked_until = case
+      when login_attempts.locked_until <= now() or login_attempts.last_failure_at <= now() - make_interval(secs => $3) then
+        case when 1 >= $2 then now() + make_interval(secs => $3) end
+      when login_attempts.locked_until is not null then login_attempts.locked_until
+      when login_attempts.failures + 1 >= $2 then now() + make_interval(secs => $3)
+    end,
+    last_failure_at = now()
+RETURNING key, failures, locked_until, last_failure_at`
+	LoginAttemptDeleteSQL        = `DELETE FROM login_attempts WHERE key = $1`
+	LoginAttemptDeleteExpiredSQL = `DELETE FROM login_attempts WHERE last_failure_at <= now() - make_interval(secs => $1) and (locked_until is null or locked_until <= now())`
+
+	RateLimitDeleteIdleSQL = `DELETE FROM rate_limits WHERE updated_at < now() - make_interval(secs => $1)`
+	// an expired key is taken over by the next request, even if it wasn't removed yet
+	IdempotencyKeyCreateSQL = `INSERT INTO idempotency_keys (key, request_hash, expires_at)
+values ($1, $2, $3)
+on conflict (key)
+  do update set request_hash = excluded.request_hash, status = null, content_type = '', response = null,
+    expires_at = excluded.expires_at, created_at = now()
+  where idempotency_keys.expires_at <= now()`
+	IdempotencyKeyGetSQL           = `SELECT key, request_hash, status, content_type, response, expires_at, created_at FROM idempotency_keys WHERE key = $1`
+	IdempotencyKeySaveResponseSQL  = `UPDATE idempotency_keys SET status = $2, content_type = $3, response = $4 WHERE key = $1`
+	IdempotencyKeyDeleteSQL        = `DELETE FROM idempotency_keys WHERE key = $1`
+	IdempotencyKeyDeleteExpiredSQL = `DELETE FROM idempotency_keys WHERE expires_at <= now()`
 )
 
+// queryer runs statements on the DB or in a transaction
+type queryer interface {
+	GetContext(ctx context.Context, dest any, query string, args ...any) error
+	SelectContext(ctx context.Context, dest any, query string, args ...any) error
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// transaction is the transaction of a method that makes several changes
+type transaction interface {
+	queryer
+	Commit() error
+	Rollback() error
+}
+
+// joinedTx is the transaction of WithTx used by a method that makes several changes. WithTx commits or rolls it back
+type joinedTx struct {
+	*sqlx.Tx
+}
+
+func (joinedTx) Commit() error {
+	return nil
+}
+
+func (joinedTx) Rollback() error {
+	return nil
+}
+
 type PostgresDBRepo struct {
-	db *sqlx.DB
+	conn *sqlx.DB
+	// db is conn, or the transaction of WithTx
+	db queryer
+	tx *sqlx.Tx
 }
 
 func NewPostgresDBRepo(db *sqlx.DB) *PostgresDBRepo {
 	return &PostgresDBRepo{
-		db: db,
+		conn: db,
+		db:   db,
+	}
+}
+
+// WithTx calls fn with a repo that makes all its changes in a single transaction. The transaction is committed if fn
+// returns nil and rolled back otherwise. Calling WithTx on the repo passed to fn runs fn in the same transaction
+func (r *PostgresDBRepo) WithTx(ctx context.Context, fn func(repo storage.DBRepo) error) error {
+	if r.tx != nil {
+		return fn(r)
+	}
+
+	tx, err := r.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(&PostgresDBRepo{conn: r.conn, db: tx, tx: tx}); err != nil {
+		return err
 	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("unable to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// beginTx starts the transaction of a method that makes several changes. Inside WithTx the method joins its transaction
+func (r *PostgresDBRepo) beginTx(ctx context.Context) (transaction, error) {
+	if r.tx != nil {
+		return joinedTx{r.tx}, nil
+	}
+
+	return r.conn.BeginTxx(ctx, nil)
 }
 
 func (r *PostgresDBRepo) GetUser(ctx context.Context, email string) (*models.User, error) {
-	ctxInner, cancel := context.WithTimeout(ctx, time.Second*queryTimeout)
+	ctxInner, cancel := startSpan(ctx, "GetUser")
 	defer cancel()
 
 	user := models.User{}
@@ -48,8 +192,21 @@ func (r *PostgresDBRepo) GetUser(ctx context.Context, email string) (*models.Use
 	return &user, nil
 }
 
+func (r *PostgresDBRepo) GetUserByID(ctx context.Context, userID string) (*models.User, error) {
+	ctxInner, cancel := startSpan(ctx, "GetUserByID")
+	defer cancel()
+
+	user := models.User{}
+	err := r.db.GetContext(ctxInner, &user, UserGetByIDSQL, userID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get user data: %w", err)
+	}
+
+	return &user, nil
+}
+
 func (r *PostgresDBRepo) GetUsers(ctx context.Context, email string) ([]models.User, error) {
-	ctxInner, cancel := context.WithTimeout(ctx, time.Second*queryTimeout)
+	ctxInner, cancel := startSpan(ctx, "GetUsers")
 	defer cancel()
 
 	users := []models.User{}
@@ -62,7 +219,7 @@ func (r *PostgresDBRepo) GetUsers(ctx context.Context, email string) ([]models.U
 }
 
 func (r *PostgresDBRepo) CreateUser(ctx context.Context, user *models.User) error {
-	ctxInner, cancel := context.WithTimeout(ctx, time.Second*queryTimeout)
+	ctxInner, cancel := startSpan(ctx, "CreateUser")
 	defer cancel()
 
 	_, err := r.db.ExecContext(ctxInner, UserCreateSQL, user.UserID, user.Email, user.Password, user.Status, user.Role)
@@ -74,7 +231,7 @@ func (r *PostgresDBRepo) CreateUser(ctx context.Context, user *models.User) erro
 }
 
 func (r *PostgresDBRepo) UpdateUser(ctx context.Context, user models.User) error {
-	ctxInner, cancel := context.WithTimeout(ctx, time.Second*queryTimeout)
+	ctxInner, cancel := startSpan(ctx, "UpdateUser")
 	defer cancel()
 
 	_, err := r.db.ExecContext(ctxInner, UserUpdateSQL, user.Email, user.Password, user.Status, user.Role, user.UserID)
@@ -86,14 +243,20 @@ func (r *PostgresDBRepo) UpdateUser(ctx context.Context, user models.User) error
 }
 
 func (r *PostgresDBRepo) DeleteUser(ctx context.Context, email string) (bool, error) {
-	ctxInner, cancel := context.WithTimeout(ctx, time.Second*queryTimeout)
+	ctxInner, cancel := startSpan(ctx, "DeleteUser")
 	defer cancel()
 
-	tx := r.db.MustBegin()
-	tx.MustExecContext(ctxInner, VerificationDeleteSQL, email)
-	result := tx.MustExecContext(ctxInner, UserDeleteSQL, email)
-	err := tx.Commit()
+	tx, err := r.beginTx(ctxInner)
+	if err != nil {
+		return false, fmt.Errorf("unable to delete user: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctxInner, VerificationDeleteSQL, email); err != nil {
+		return false, fmt.Errorf("unable to delete verification data: %w", err)
+	}
 
+	result, err := tx.ExecContext(ctxInner, UserDeleteSQL, email)
 	if err != nil {
 		return false, fmt.Errorf("unable to delete user: %w", err)
 	}
@@ -103,11 +266,70 @@ func (r *PostgresDBRepo) DeleteUser(ctx context.Context, email string) (bool, er
 		return false, fmt.Errorf("delete user - unexpected error: %w", err)
 	}
 
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("unable to delete user: %w", err)
+	}
+
 	return rowsAffected > 0, nil
 }
 
+// RoleExists returns true if at least one user has the role
+func (r *PostgresDBRepo) RoleExists(ctx context.Context, role string) (bool, error) {
+	ctxInner, cancel := startSpan(ctx, "RoleExists")
+	defer cancel()
+
+	var exists bool
+	err := r.db.GetContext(ctxInner, &exists, UserRoleExistsSQL, role)
+	if err != nil {
+		return false, fmt.Errorf("unable to check role: %w", err)
+	}
+
+	return exists, nil
+}
+
+// IncrementTokenVersion invalidates all tokens, refresh tokens and sessions of a user in a single transaction.
+// It returns false if the user does not exist
+func (r *PostgresDBRepo) IncrementTokenVersion(ctx context.Context, userID string) (bool, error) {
+	ctxInner, cancel := startSpan(ctx, "IncrementTokenVersion")
+	defer cancel()
+
+	tx, err := r.beginTx(ctxInner)
+	if err != nil {
+		return false, fmt.Errorf("unable to increment token version: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctxInner, UserIncrementTokenVersionSQL, userID)
+	if err != nil {
+		return false, fmt.Errorf("unable to increment token version: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("increment token version - unexpected error: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctxInner, RefreshTokenRevokeUserSQL, userID); err != nil {
+		return false, fmt.Errorf("unable to revoke refresh tokens: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctxInner, SessionDeleteUserSQL, userID); err != nil {
+		return false, fmt.Errorf("unable to delete sessions: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("unable to increment token version: %w", err)
+	}
+
+	return true, nil
+}
+
 func (r *PostgresDBRepo) InsertOrUpdateVerification(ctx context.Context, verification models.Verification) error {
-	ctxInner, cancel := context.WithTimeout(ctx, time.Second*queryTimeout)
+	ctxInner, cancel := startSpan(ctx, "InsertOrUpdateVerification")
 	defer cancel()
 
 	_, err := r.db.ExecContext(ctxInner, VerificationUpsertSQL, verification.Email, verification.VerificationType, verification.VerificationCode, verification.ExpiresAt, verification.AttemptsRemaining)
@@ -119,7 +341,7 @@ func (r *PostgresDBRepo) InsertOrUpdateVerification(ctx context.Context, verific
 }
 
 func (r *PostgresDBRepo) GetVerification(ctx context.Context, verificationType string, email string) (*models.Verification, error) {
-	ctxInner, cancel := context.WithTimeout(ctx, time.Second*queryTimeout)
+	ctxInner, cancel := startSpan(ctx, "GetVerification")
 	defer cancel()
 
 	var verification models.Verification
@@ -132,7 +354,7 @@ func (r *PostgresDBRepo) GetVerification(ctx context.Context, verificationType s
 }
 
 func (r *PostgresDBRepo) DeleteVerification(ctx context.Context, email string) error {
-	ctxInner, cancel := context.WithTimeout(ctx, time.Second*queryTimeout)
+	ctxInner, cancel := startSpan(ctx, "DeleteVerification")
 	defer cancel()
 
 	_, err := r.db.ExecContext(ctxInner, VerificationDeleteSQL, email)
@@ -142,3 +364,465 @@ func (r *PostgresDBRepo) DeleteVerification(ctx context.Context, email string) e
 
 	return nil
 }
+
+func (r *PostgresDBRepo) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	ctxInner, cancel := startSpan(ctx, "CreateRefreshToken")
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctxInner, RefreshTokenCreateSQL, token.TokenID, token.FamilyID, token.UserID, token.TokenHash, token.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("unable to insert refresh token: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresDBRepo) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	ctxInner, cancel := startSpan(ctx, "GetRefreshToken")
+	defer cancel()
+
+	var token models.RefreshToken
+	err := r.db.GetContext(ctxInner, &token, RefreshTokenGetSQL, tokenHash)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get refresh token: %w", err)
+	}
+
+	return &token, nil
+}
+
+// RotateRefreshToken revokes the old token and stores its replacement in a single transaction.
+// It returns false if the old token had already been revoked (e.g. by a concurrent request)
+func (r *PostgresDBRepo) RotateRefreshToken(ctx context.Context, oldTokenID string, newToken *models.RefreshToken) (bool, error) {
+	ctxInner, cancel := startSpan(ctx, "RotateRefreshToken")
+	defer cancel()
+
+	tx, err := r.beginTx(ctxInner)
+	if err != nil {
+		return false, fmt.Errorf("unable to rotate refresh token: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctxInner, RefreshTokenRevokeSQL, oldTokenID, newToken.TokenID)
+	if err != nil {
+		return false, fmt.Errorf("unable to revoke refresh token: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("rotate refresh token - unexpected error: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(ctxInner, RefreshTokenCreateSQL, newToken.TokenID, newToken.FamilyID, newToken.UserID, newToken.TokenHash, newToken.ExpiresAt)
+	if err != nil {
+		return false, fmt.Errorf("unable to insert refresh token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("unable to rotate refresh token: %w", err)
+	}
+
+	return true, nil
+}
+
+func (r *PostgresDBRepo) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	ctxInner, cancel := startSpan(ctx, "RevokeRefreshTokenFamily")
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctxInner, RefreshTokenRevokeFamilySQL, familyID)
+	if err != nil {
+		return fmt.Errorf("unable to revoke refresh token family: %w", err)
+	}
+
+	return nil
+}
+
+// GetSigningKeys returns all signing keys, including retired keys, from oldest to newest
+func (r *PostgresDBRepo) GetSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	ctxInner, cancel := startSpan(ctx, "GetSigningKeys")
+	defer cancel()
+
+	keys := []models.SigningKey{}
+	err := r.db.SelectContext(ctxInner, &keys, SigningKeyGetAllSQL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get signing keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RotateSigningKey stores a new active signing key and schedules the retirement of the previously active key
+func (r *PostgresDBRepo) RotateSigningKey(ctx context.Context, key *models.SigningKey, retireAt time.Time) error {
+	ctxInner, cancel := startSpan(ctx, "RotateSigningKey")
+	defer cancel()
+
+	tx, err := r.beginTx(ctxInner)
+	if err != nil {
+		return fmt.Errorf("unable to rotate signing key: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctxInner, SigningKeyRetireSQL, retireAt); err != nil {
+		return fmt.Errorf("unable to retire signing key: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctxInner, SigningKeyCreateSQL, key.KeyID, key.Algorithm, key.EncryptedPrivateKey); err != nil {
+		return fmt.Errorf("unable to insert signing key: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("unable to rotate signing key: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeToken adds a token to the denylist until the token expires
+func (r *PostgresDBRepo) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	ctxInner, cancel := startSpan(ctx, "RevokeToken")
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctxInner, RevokedTokenInsertSQL, tokenID, expiresAt)
+	if err != nil {
+		return fmt.Errorf("unable to revoke token: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresDBRepo) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	ctxInner, cancel := startSpan(ctx, "IsTokenRevoked")
+	defer cancel()
+
+	var revoked bool
+	err := r.db.GetContext(ctxInner, &revoked, RevokedTokenExistsSQL, tokenID)
+	if err != nil {
+		return false, fmt.Errorf("unable to check revoked token: %w", err)
+	}
+
+	return revoked, nil
+}
+
+// DeleteExpiredRevokedTokens removes tokens from the denylist that would be rejected anyway because they have expired
+func (r *PostgresDBRepo) DeleteExpiredRevokedTokens(ctx context.Context) (int64, error) {
+	ctxInner, cancel := startSpan(ctx, "DeleteExpiredRevokedTokens")
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctxInner, RevokedTokenDeleteExpiredSQL)
+	if err != nil {
+		return 0, fmt.Errorf("unable to delete expired revoked tokens: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("delete expired revoked tokens - unexpected error: %w", err)
+	}
+
+	return rowsAffected, nil
+}
+
+func (r *PostgresDBRepo) CreateOAuthClient(ctx context.Context, client *models.OAuthClient) error {
+	ctxInner, cancel := startSpan(ctx, "CreateOAuthClient")
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctxInner, OAuthClientCreateSQL, client.ClientID, client.ClientSecretHash, client.Name, client.Scopes, client.RedirectURIs)
+	if err != nil {
+		return fmt.Errorf("unable to insert oauth client: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresDBRepo) GetOAuthClient(ctx context.Context, clientID string) (*models.OAuthClient, error) {
+	ctxInner, cancel := startSpan(ctx, "GetOAuthClient")
+	defer cancel()
+
+	var client models.OAuthClient
+	err := r.db.GetContext(ctxInner, &client, OAuthClientGetSQL, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get oauth client: %w", err)
+	}
+
+	return &client, nil
+}
+
+func (r *PostgresDBRepo) CreateAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error {
+	ctxInner, cancel := startSpan(ctx, "CreateAuthorizationCode")
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctxInner, AuthorizationCodeCreateSQL, code.CodeHash, code.ClientID, code.UserID, code.RedirectURI, code.Scope, code.CodeChallenge, code.Nonce, code.AuthTime, code.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("unable to insert authorization code: %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeAuthorizationCode marks an authorization code as used and returns it. sql.ErrNoRows is returned if the code
+// does not exist or has already been used, so a code can only be exchanged once
+func (r *PostgresDBRepo) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*models.AuthorizationCode, error) {
+	ctxInner, cancel := startSpan(ctx, "ConsumeAuthorizationCode")
+	defer cancel()
+
+	var code models.AuthorizationCode
+	err := r.db.GetContext(ctxInner, &code, AuthorizationCodeConsumeSQL, codeHash)
+	if err != nil {
+		return nil, fmt.Errorf("unable to consume authorization code: %w", err)
+	}
+
+	return &code, nil
+}
+
+func (r *PostgresDBRepo) DeleteExpiredAuthorizationCodes(ctx context.Context) (int64, error) {
+	ctxInner, cancel := startSpan(ctx, "DeleteExpiredAuthorizationCodes")
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctxInner, AuthorizationCodeDeleteExpiredSQL)
+	if err != nil {
+		return 0, fmt.Errorf("unable to delete expired authorization codes: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("delete expired authorization codes - unexpected error: %w", err)
+	}
+
+	return rowsAffected, nil
+}
+
+func (r *PostgresDBRepo) CreateSession(ctx context.Context, session *models.Session) error {
+	ctxInner, cancel := startSpan(ctx, "CreateSession")
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctxInner, SessionCreateSQL, session.SessionID, session.TokenHash, session.CSRFTokenHash, session.UserID, session.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("unable to insert session: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresDBRepo) GetSession(ctx context.Context, tokenHash string) (*models.Session, error) {
+	ctxInner, cancel := startSpan(ctx, "GetSession")
+	defer cancel()
+
+	var session models.Session
+	err := r.db.GetContext(ctxInner, &session, SessionGetSQL, tokenHash)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get session: %w", err)
+	}
+
+	return &session, nil
+}
+
+func (r *PostgresDBRepo) DeleteSession(ctx context.Context, sessionID string) error {
+	ctxInner, cancel := startSpan(ctx, "DeleteSession")
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctxInner, SessionDeleteSQL, sessionID)
+	if err != nil {
+		return fmt.Errorf("unable to delete session: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresDBRepo) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	ctxInner, cancel := startSpan(ctx, "DeleteExpiredSessions")
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctxInner, SessionDeleteExpiredSQL)
+	if err != nil {
+		return 0, fmt.Errorf("unable to delete expired sessions: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("delete expired sessions - unexpected error: %w", err)
+	}
+
+	return rowsAffected, nil
+}
+
+func (r *PostgresDBRepo) CreateImpersonation(ctx context.Context, impersonation *models.Impersonation) error {
+	ctxInner, cancel := startSpan(ctx, "CreateImpersonation")
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctxInner, ImpersonationCreateSQL, impersonation.ImpersonationID, impersonation.Actor, impersonation.UserID, impersonation.TokenID, impersonation.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("unable to insert impersonation: %w", err)
+	}
+
+	return nil
+}
+
+// TakeRateLimitToken takes a token from the token bucket of the key in a single statement, so replicas of the api
+// can share the bucket. It returns the tokens left in the bucket and whether a token was taken
+func (r *PostgresDBRepo) TakeRateLimitToken(ctx context.Context, key string, capacity float64, refillRate float64) (float64, bool, error) {
+	ctxInner, cancel := startSpan(ctx, "TakeRateLimitToken")
+	defer cancel()
+
+	var bucket struct {
+		Tokens  float64 `db:"tokens"`
+		Allowed bool    `db:"allowed"`
+	}
+
+	err := r.db.GetContext(ctxInner, &bucket, RateLimitTakeSQL, key, capacity, refillRate)
+	if err != nil {
+		return 0, false, fmt.Errorf("unable to take rate limit token: %w", err)
+	}
+
+	return bucket.Tokens, bucket.Allowed, nil
+}
+
+func (r *PostgresDBRepo) DeleteIdleRateLimits(ctx context.Context, idleFor time.Duration) (int64, error) {
+	ctxInner, cancel := startSpan(ctx, "DeleteIdleRateLimits")
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctxInner, RateLimitDeleteIdleSQL, idleFor.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("unable to delete idle rate limits: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("delete idle rate limits - unexpected error: %w", err)
+	}
+
+	return rowsAffected, nil
+}
+
+func (r *PostgresDBRepo) GetLoginAttempt(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	ctxInner, cancel := startSpan(ctx, "GetLoginAttempt")
+	defer cancel()
+
+	attempt := models.LoginAttempt{}
+	err := r.db.GetContext(ctxInner, &attempt, LoginAttemptGetSQL, key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get login attempt: %w", err)
+	}
+
+	return &attempt, nil
+}
+
+// RecordLoginFailure counts a failed sign in attempt in a single statement, so concurrent attempts are all counted.
+// The key is locked for lockoutDuration once it has maxFailures failed attempts
+func (r *PostgresDBRepo) RecordLoginFailure(ctx context.Context, key string, maxFailures int, lockoutDuration time.Duration) (*models.LoginAttempt, error) {
+	ctxInner, cancel := startSpan(ctx, "RecordLoginFailure")
+	defer cancel()
+
+	attempt := models.LoginAttempt{}
+	err := r.db.GetContext(ctxInner, &attempt, LoginAttemptRecordFailureSQL, key, maxFailures, lockoutDuration.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("unable to record login failure: %w", err)
+	}
+
+	return &attempt, nil
+}
+
+func (r *PostgresDBRepo) DeleteLoginAttempt(ctx context.Context, key string) error {
+	ctxInner, cancel := startSpan(ctx, "DeleteLoginAttempt")
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctxInner, LoginAttemptDeleteSQL, key)
+	if err != nil {
+		return fmt.Errorf("unable to delete login attempt: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteExpiredLoginAttempts removes the failed attempts that are forgotten
+func (r *PostgresDBRepo) DeleteExpiredLoginAttempts(ctx context.Context, lockoutDuration time.Duration) (int64, error) {
+	ctxInner, cancel := startSpan(ctx, "DeleteExpiredLoginAttempts")
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctxInner, LoginAttemptDeleteExpiredSQL, lockoutDuration.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("unable to delete expired login attempts: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("delete expired login attempts - unexpected error: %w", err)
+	}
+
+	return rowsAffected, nil
+}
+
+// CreateIdempotencyKey stores a key for a request that is being handled. It returns false if the key is already used
+// by a request that didn't expire
+func (r *PostgresDBRepo) CreateIdempotencyKey(ctx context.Context, key *models.IdempotencyKey) (bool, error) {
+	ctxInner, cancel := startSpan(ctx, "CreateIdempotencyKey")
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctxInner, IdempotencyKeyCreateSQL, key.Key, key.RequestHash, key.ExpiresAt)
+	if err != nil {
+		return false, fmt.Errorf("unable to create idempotency key: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("create idempotency key - unexpected error: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (r *PostgresDBRepo) GetIdempotencyKey(ctx context.Context, key string) (*models.IdempotencyKey, error) {
+	ctxInner, cancel := startSpan(ctx, "GetIdempotencyKey")
+	defer cancel()
+
+	idempotencyKey := models.IdempotencyKey{}
+	err := r.db.GetContext(ctxInner, &idempotencyKey, IdempotencyKeyGetSQL, key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get idempotency key: %w", err)
+	}
+
+	return &idempotencyKey, nil
+}
+
+func (r *PostgresDBRepo) SaveIdempotencyResponse(ctx context.Context, key string, status int, contentType string, response []byte) error {
+	ctxInner, cancel := startSpan(ctx, "SaveIdempotencyResponse")
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctxInner, IdempotencyKeySaveResponseSQL, key, status, contentType, response)
+	if err != nil {
+		return fmt.Errorf("unable to save idempotency response: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresDBRepo) DeleteIdempotencyKey(ctx context.Context, key string) error {
+	ctxInner, cancel := startSpan(ctx, "DeleteIdempotencyKey")
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctxInner, IdempotencyKeyDeleteSQL, key)
+	if err != nil {
+		return fmt.Errorf("unable to delete idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys removes the keys that can't be replayed anymore
+func (r *PostgresDBRepo) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	ctxInner, cancel := startSpan(ctx, "DeleteExpiredIdempotencyKeys")
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctxInner, IdempotencyKeyDeleteExpiredSQL)
+	if err != nil {
+		return 0, fmt.Errorf("unable to delete expired idempotency keys: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("delete expired idempotency keys - unexpected error: %w", err)
+	}
+
+	return rowsAffected, nil
+}
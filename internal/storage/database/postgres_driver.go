@@ -1,20 +1,33 @@
 package database
 
 import (
+	"auth_api/internal/tracing"
+	"context"
+	"log/slog"
 	"time"
 
 	_ "github.com/jackc/pgconn"
-	_ "github.com/jackc/pgx/v5"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func ConnectToPostgres(connectionStr string) (*sqlx.DB, error) {
+var tracer = tracing.Tracer("database")
+
+// ConnectToPostgres connects to the DB. Every statement gets a span, and statements that take longer than
+// slowQueryThreshold are logged. Slow statements aren't logged if slowQueryThreshold is zero
+func ConnectToPostgres(connectionStr string, logger *slog.Logger, slowQueryThreshold time.Duration) (*sqlx.DB, error) {
 	// dsn := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", username, password, host, port, dbname)
-	db, err := sqlx.Open("pgx", connectionStr)
+	config, err := pgx.ParseConfig(connectionStr)
 	if err != nil {
 		return nil, err
 	}
+	config.Tracer = &queryTracer{logger: logger, slowQueryThreshold: slowQueryThreshold}
+
+	db := sqlx.NewDb(stdlib.OpenDB(*config), "pgx")
 
 	db.SetMaxOpenConns(25)
 	db.SetMaxIdleConns(25)
@@ -27,3 +40,57 @@ func ConnectToPostgres(connectionStr string) (*sqlx.DB, error) {
 
 	return db, nil
 }
+
+type queryContextKey struct{}
+
+// query is the statement a span was started for
+type query struct {
+	sql   string
+	start time.Time
+}
+
+// queryTracer traces the statements sent to the DB
+type queryTracer struct {
+	logger             *slog.Logger
+	slowQueryThreshold time.Duration
+}
+
+func (t *queryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = tracer.Start(ctx, "db.query",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "postgresql"), attribute.String("db.statement", data.SQL)),
+	)
+
+	return context.WithValue(ctx, queryContextKey{}, query{sql: data.SQL, start: time.Now()})
+}
+
+func (t *queryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	defer span.End()
+
+	if data.Err != nil {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	}
+
+	q, ok := ctx.Value(queryContextKey{}).(query)
+	if !ok || t.slowQueryThreshold <= 0 {
+		return
+	}
+
+	if duration := time.Since(q.start); duration > t.slowQueryThreshold {
+		// the arguments aren't logged, since they can contain passwords and tokens
+		t.logger.WarnContext(ctx, "slow query", "sql", q.sql, "duration", duration, "trace_id", span.SpanContext().TraceID().String())
+	}
+}
+
+// startSpan starts the span of a repo method and applies the query timeout. cancel ends the span
+func startSpan(ctx context.Context, method string) (context.Context, context.CancelFunc) {
+	ctx, span := tracer.Start(ctx, "PostgresDBRepo."+method, trace.WithAttributes(attribute.String("db.system", "postgresql")))
+	ctx, cancel := context.WithTimeout(ctx, time.Second*queryTimeout)
+
+	return ctx, func() {
+		cancel()
+		span.End()
+	}
+}
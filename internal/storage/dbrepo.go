@@ -3,15 +3,52 @@ package storage
 import (
 	"auth_api/internal/models"
 	"context"
+	"time"
 )
 
 type DBRepo interface {
+	// WithTx calls fn with a repo that makes all its changes in a single transaction, which is rolled back if fn
+	// returns an error
+	WithTx(ctx context.Context, fn func(repo DBRepo) error) error
 	GetUser(ctx context.Context, email string) (*models.User, error)
+	GetUserByID(ctx context.Context, userID string) (*models.User, error)
 	GetUsers(ctx context.Context, email string) ([]models.User, error)
 	CreateUser(ctx context.Context, user *models.User) error
 	UpdateUser(ctx context.Context, user models.User) error
 	DeleteUser(ctx context.Context, email string) (bool, error)
+	IncrementTokenVersion(ctx context.Context, userID string) (bool, error)
+	RoleExists(ctx context.Context, role string) (bool, error)
 	InsertOrUpdateVerification(ctx context.Context, verification models.Verification) error
 	GetVerification(ctx context.Context, verificationType string, email string) (*models.Verification, error)
 	DeleteVerification(ctx context.Context, email string) error
+	CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, oldTokenID string, newToken *models.RefreshToken) (bool, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	GetSigningKeys(ctx context.Context) ([]models.SigningKey, error)
+	RotateSigningKey(ctx context.Context, key *models.SigningKey, retireAt time.Time) error
+	RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, tokenID string) (bool, error)
+	DeleteExpiredRevokedTokens(ctx context.Context) (int64, error)
+	CreateOAuthClient(ctx context.Context, client *models.OAuthClient) error
+	GetOAuthClient(ctx context.Context, clientID string) (*models.OAuthClient, error)
+	CreateAuthorizationCode(ctx context.Context, code *models.AuthorizationCode) error
+	ConsumeAuthorizationCode(ctx context.Context, codeHash string) (*models.AuthorizationCode, error)
+	DeleteExpiredAuthorizationCodes(ctx context.Context) (int64, error)
+	CreateSession(ctx context.Context, session *models.Session) error
+	GetSession(ctx context.Context, tokenHash string) (*models.Session, error)
+	DeleteSession(ctx context.Context, sessionID string) error
+	DeleteExpiredSessions(ctx context.Context) (int64, error)
+	CreateImpersonation(ctx context.Context, impersonation *models.Impersonation) error
+	TakeRateLimitToken(ctx context.Context, key string, capacity float64, refillRate float64) (float64, bool, error)
+	DeleteIdleRateLimits(ctx context.Context, idleFor time.Duration) (int64, error)
+	GetLoginAttempt(ctx context.Context, key string) (*models.LoginAttempt, error)
+	RecordLoginFailure(ctx context.Context, key string, maxFailures int, lockoutDuration time.Duration) (*models.LoginAttempt, error)
+	DeleteLoginAttempt(ctx context.Context, key string) error
+	DeleteExpiredLoginAttempts(ctx context.Context, lockoutDuration time.Duration) (int64, error)
+	CreateIdempotencyKey(ctx context.Context, key *models.IdempotencyKey) (bool, error)
+	GetIdempotencyKey(ctx context.Context, key string) (*models.IdempotencyKey, error)
+	SaveIdempotencyResponse(ctx context.Context, key string, status int, contentType string, response []byte) error
+	DeleteIdempotencyKey(ctx context.Context, key string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error)
 }
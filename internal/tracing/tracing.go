@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters spans can be sent to
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+const serviceName = "auth_api"
+
+// Config configures where spans are exported to
+type Config struct {
+	// Exporter is one of the Exporter constants
+	Exporter string
+	// OTLPEndpoint is the url of the OTLP/HTTP collector, e.g. http://localhost:4318. When it is empty the standard
+	// OTEL_EXPORTER_OTLP_* environment variables are used
+	OTLPEndpoint string
+	// Stdout receives the spans of the stdout exporter
+	Stdout io.Writer
+}
+
+// Setup installs the global tracer provider and the W3C trace context propagator. Incoming traceparent headers are
+// continued even when spans aren't exported. The returned function flushes and stops the exporter
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(config.Stdout))
+	case ExporterOTLP:
+		var options []otlptracehttp.Option
+		if config.OTLPEndpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(config.OTLPEndpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, options...)
+	default:
+		return nil, fmt.Errorf("unknown exporter %q: must be one of %s, %s or %s", config.Exporter, ExporterNone, ExporterStdout, ExporterOTLP)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %s exporter: %w", config.Exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer of a package of the api. Tracers created before Setup use the tracer provider installed
+// by Setup once it is called
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(serviceName + "/" + name)
+}
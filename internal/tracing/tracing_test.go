@@ -0,0 +1,41 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		desc     string
+		exporter string
+		err      string
+		exported bool
+	}{
+		{desc: "default", exporter: ""},
+		{desc: "none", exporter: ExporterNone},
+		{desc: "stdout", exporter: ExporterStdout, exported: true},
+		{desc: "unknown exporter", exporter: "zipkin", err: `unknown exporter "zipkin": must be one of none, stdout or otlp`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var stdout bytes.Buffer
+			shutdown, err := Setup(context.Background(), Config{Exporter: test.exporter, Stdout: &stdout})
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+
+			_, span := Tracer("test").Start(context.Background(), "test span")
+			span.End()
+
+			assert.NoError(t, shutdown(context.Background()))
+			assert.Equal(t, test.exported, bytes.Contains(stdout.Bytes(), []byte(`"Name":"test span"`)))
+		})
+	}
+}
@@ -2,6 +2,7 @@ package validator
 
 import (
 	"fmt"
+	"net/url"
 	"regexp"
 	"strings"
 )
@@ -12,6 +13,8 @@ const (
 
 var (
 	EmailRegex = regexp.MustCompile(EmailRegexStr)
+	// ScopeRegex matches a scope-token (RFC 6749 section 3.3)
+	ScopeRegex = regexp.MustCompile(`^[\x21\x23-\x5B\x5D-\x7E]+$`)
 )
 
 type Validator struct {
@@ -92,3 +95,17 @@ func NotBlank(value string) bool {
 func IsEmail(value string) bool {
 	return EmailRegex.MatchString(value)
 }
+
+func IsScope(value string) bool {
+	return ScopeRegex.MatchString(value)
+}
+
+// IsRedirectURI checks that the value is an absolute uri without a fragment (RFC 6749 section 3.1.2)
+func IsRedirectURI(value string) bool {
+	uri, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+
+	return uri.IsAbs() && uri.Fragment == "" && !strings.Contains(value, "#")
+}
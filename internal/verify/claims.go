@@ -0,0 +1,108 @@
+package verify
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Claims are the claims of a token. Custom contains any claims that are not registered (RFC 7519 section 4.1)
+// and are not the email, role, token_version or scope claims
+type Claims struct {
+	ID        string
+	Issuer    string
+	Subject   string
+	Audience  []string
+	IssuedAt  time.Time
+	NotBefore time.Time
+	ExpiresAt time.Time
+	Email     string
+	Role      string
+	// TokenVersion is the token version of the user when the token was issued. Tokens of users with a newer token
+	// version are rejected
+	TokenVersion int
+	// Scopes are the space separated scopes in the scope claim (RFC 8693 section 4.2)
+	Scopes []string
+	Custom map[string]any
+}
+
+// registeredClaimNames are the names of the claims that have a field in Claims
+var registeredClaimNames = []string{"jti", "iss", "sub", "aud", "iat", "nbf", "exp", "email", "role", "token_version", "scope"}
+
+// IsUserToken reports whether the token was issued to a user. Tokens issued to users carry the user id as subject,
+// while tokens issued using the client credentials grant carry the client id
+func (c Claims) IsUserToken() bool {
+	clientID, _ := c.Custom["client_id"].(string)
+	return c.Subject != clientID && uuid.Validate(c.Subject) == nil
+}
+
+// HasScopes reports whether all the scopes were granted to the token
+func (c Claims) HasScopes(scopes ...string) bool {
+	for _, scope := range scopes {
+		if !slices.Contains(c.Scopes, scope) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsImpersonated reports whether the token was issued to an admin acting as the user. The admin is named in the act
+// claim (RFC 8693 section 4.1)
+func (c Claims) IsImpersonated() bool {
+	_, ok := c.Custom["act"]
+	return ok
+}
+
+// privateClaims returns the email, role, token_version, scope and custom claims. Custom claims can't replace registered
+// claims
+func privateClaims(claims Claims) map[string]any {
+	values := map[string]any{}
+	for name, value := range claims.Custom {
+		if !slices.Contains(registeredClaimNames, name) {
+			values[name] = value
+		}
+	}
+
+	if claims.Email != "" {
+		values["email"] = claims.Email
+	}
+
+	if claims.Role != "" {
+		values["role"] = claims.Role
+	}
+
+	if claims.TokenVersion != 0 {
+		values["token_version"] = claims.TokenVersion
+	}
+
+	if len(claims.Scopes) > 0 {
+		values["scope"] = strings.Join(claims.Scopes, " ")
+	}
+
+	return values
+}
+
+// setPrivateClaims sets the email, role, token_version, scope and custom claims from the claims of a validated token
+func (c *Claims) setPrivateClaims(values map[string]any) {
+	c.Email, _ = values["email"].(string)
+	c.Role, _ = values["role"].(string)
+
+	// json numbers are decoded as float64. Tokens without the claim were issued before the first version bump
+	if tokenVersion, ok := values["token_version"].(float64); ok {
+		c.TokenVersion = int(tokenVersion)
+	}
+
+	if scope, ok := values["scope"].(string); ok {
+		c.Scopes = strings.Fields(scope)
+	}
+
+	c.Custom = map[string]any{}
+	for name, value := range values {
+		if !slices.Contains(registeredClaimNames, name) {
+			c.Custom[name] = value
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package verify
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+)
+
+// JWK is a JSON Web Key (RFC 7517) containing a public key
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set as published on a jwks.json endpoint
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK creates a signature verification JWK for a RSA or Ed25519 public key
+func NewJWK(publicKey crypto.PublicKey, algorithm string) (JWK, error) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType:   "RSA",
+			Use:       "sig",
+			Algorithm: algorithm,
+			N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType:   "OKP",
+			Use:       "sig",
+			Algorithm: algorithm,
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(key),
+		}, nil
+	default:
+		return JWK{}, ErrorUnsupportedSigningMethod
+	}
+}
@@ -6,34 +6,84 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	SigningMethodHS256 = "HS256"
+	SigningMethodRS256 = "RS256"
+	SigningMethodEdDSA = "EdDSA"
 )
 
 var (
-	ErrorTokenValidationFailed = errors.New("token validation failed")
-	ErrorTokenInvalid          = errors.New("invalid token")
+	ErrorTokenValidationFailed    = errors.New("token validation failed")
+	ErrorTokenInvalid             = errors.New("invalid token")
+	ErrorUnsupportedSigningMethod = errors.New("unsupported signing method")
 )
 
+// TokenConfig contains the configuration of a signing key. Secret is only used by HS256 and PrivateKeyPEM is only
+// used by RS256 and EdDSA
+type TokenConfig struct {
+	SigningMethod string
+	Secret        string
+	PrivateKeyPEM []byte
+}
+
 type TokenUtils interface {
-	Setup(secret string)
-	GenerateToken(userID string, hours int) (string, error)
+	Setup(keyring *Keyring, issuer, audience string) error
+	GenerateToken(claims Claims, expiresIn time.Duration) (string, error)
+	ValidateToken(tokenStr string) (*Claims, error)
+	JWKS() JWKSet
+	// SigningMethods returns the algorithms of the keys that can be used to issue tokens
+	SigningMethods() []string
 }
 
 type JWTTokenUtils struct {
-	secret string
+	keyring  *Keyring
+	issuer   string
+	audience string
 }
 
-func (t *JWTTokenUtils) Setup(secret string) {
-	t.secret = secret
+// Setup configures the keys used to sign and validate tokens. Tokens are issued for, and only accepted from, the
+// issuer and audience
+func (t *JWTTokenUtils) Setup(keyring *Keyring, issuer, audience string) error {
+	if err := validateTokenSettings(keyring, issuer, audience); err != nil {
+		return err
+	}
+
+	t.keyring = keyring
+	t.issuer = issuer
+	t.audience = audience
+	return nil
 }
 
-func (t *JWTTokenUtils) GenerateToken(userID string, hours int) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub": userID,
-		"exp": time.Now().Add(time.Hour * time.Duration(hours)).Unix(),
-	})
+// GenerateToken signs a token containing the claims. The iss, iat, nbf and exp claims are always set by the token
+// utils and the jti claim defaults to a random uuid. The aud claim defaults to the audience of the token utils, e.g.
+// an id_token is issued for a client instead
+func (t *JWTTokenUtils) GenerateToken(claims Claims, expiresIn time.Duration) (string, error) {
+	key, err := t.keyring.ActiveKey()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	mapClaims := jwt.MapClaims(privateClaims(claims))
+	mapClaims["jti"] = tokenID(claims)
+	mapClaims["iss"] = t.issuer
+	mapClaims["sub"] = claims.Subject
+	mapClaims["aud"] = t.audience
+	if len(claims.Audience) > 0 {
+		mapClaims["aud"] = claims.Audience
+	}
+	mapClaims["iat"] = now.Unix()
+	mapClaims["nbf"] = now.Unix()
+	mapClaims["exp"] = now.Add(expiresIn).Unix()
 
-	// Sign and get the complete encoded token as a string using the secret
-	tokenString, err := token.SignedString([]byte(t.secret))
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), mapClaims)
+	token.Header["kid"] = key.KeyID
+
+	// Sign and get the complete encoded token as a string using the active key
+	tokenString, err := token.SignedString(key.PrivateKey)
 	if err != nil {
 		return "", err
 	}
@@ -41,22 +91,102 @@ func (t *JWTTokenUtils) GenerateToken(userID string, hours int) (string, error)
 	return tokenString, nil
 }
 
-func (t *JWTTokenUtils) ValidateToken(tokenStr string) (error) {
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+// SigningMethods returns the JWT signing algorithms
+func (t *JWTTokenUtils) SigningMethods() []string {
+	return []string{SigningMethodHS256, SigningMethodRS256, SigningMethodEdDSA}
+}
+
+// ValidateToken checks the signature, issuer, audience and validity period of a token and returns its claims
+func (t *JWTTokenUtils) ValidateToken(tokenStr string) (*Claims, error) {
+	mapClaims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenStr, mapClaims, func(token *jwt.Token) (interface{}, error) {
+		keyID, _ := token.Header["kid"].(string)
+		key, err := t.keyring.Key(keyID)
+		if err != nil {
+			return nil, err
+		}
+
+		// only accept the algorithm of the key, e.g. a HS256 token may not be validated using a RSA public key
+		if token.Method.Alg() != key.Algorithm {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 
-		return []byte(t.secret), nil
-	})
+		return key.PublicKey, nil
+	}, jwt.WithIssuer(t.issuer), jwt.WithAudience(t.audience))
 
 	if err != nil {
-		return ErrorTokenValidationFailed
+		return nil, ErrorTokenValidationFailed
 	}
 
 	if !token.Valid {
-		return ErrorTokenInvalid
+		return nil, ErrorTokenInvalid
+	}
+
+	return claimsFromMap(mapClaims), nil
+}
+
+// JWKS returns the public keys that can be used to validate tokens. Symmetric keys are never published
+func (t *JWTTokenUtils) JWKS() JWKSet {
+	keySet := JWKSet{Keys: []JWK{}}
+
+	for _, key := range t.keyring.Keys() {
+		if !key.IsAsymmetric() {
+			continue
+		}
+
+		jwk, err := NewJWK(key.PublicKey, key.Algorithm)
+		if err != nil {
+			continue
+		}
+
+		jwk.KeyID = key.KeyID
+		keySet.Keys = append(keySet.Keys, jwk)
+	}
+
+	return keySet
+}
+
+// claimsFromMap converts the claims of a validated token
+func claimsFromMap(mapClaims jwt.MapClaims) *Claims {
+	claims := &Claims{}
+	claims.ID, _ = mapClaims["jti"].(string)
+	claims.Issuer, _ = mapClaims.GetIssuer()
+	claims.Subject, _ = mapClaims.GetSubject()
+	claims.Audience, _ = mapClaims.GetAudience()
+	claims.setPrivateClaims(mapClaims)
+
+	if issuedAt, _ := mapClaims.GetIssuedAt(); issuedAt != nil {
+		claims.IssuedAt = issuedAt.Time
+	}
+
+	if notBefore, _ := mapClaims.GetNotBefore(); notBefore != nil {
+		claims.NotBefore = notBefore.Time
+	}
+
+	if expiresAt, _ := mapClaims.GetExpirationTime(); expiresAt != nil {
+		claims.ExpiresAt = expiresAt.Time
+	}
+
+	return claims
+}
+
+func validateTokenSettings(keyring *Keyring, issuer, audience string) error {
+	if keyring == nil {
+		return errors.New("token utils require a keyring")
+	}
+
+	if issuer == "" || audience == "" {
+		return errors.New("token utils require an issuer and audience")
 	}
 
 	return nil
 }
+
+// tokenID returns the ID of the claims, or a new random ID
+func tokenID(claims Claims) string {
+	if claims.ID != "" {
+		return claims.ID
+	}
+
+	return uuid.New().String()
+}
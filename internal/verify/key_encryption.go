@@ -0,0 +1,64 @@
+package verify
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+)
+
+// KeyEncryptor encrypts the private keys of the signing keys that are stored in the DB using AES-256-GCM, so reading
+// the DB isn't enough to forge tokens
+type KeyEncryptor struct {
+	aead cipher.AEAD
+}
+
+// NewKeyEncryptor creates a KeyEncryptor using a hex encoded 32 byte key
+func NewKeyEncryptor(hexKey string) (*KeyEncryptor, error) {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("key encryption key must be 32 hex encoded bytes")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyEncryptor{aead: aead}, nil
+}
+
+// Encrypt returns the base64 encoded nonce and ciphertext of a private key. The key ID is authenticated as well, so
+// an encrypted private key can't be stored under another key ID
+func (e *KeyEncryptor) Encrypt(keyID string, privateKey []byte) (string, error) {
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", errors.New("unable to encrypt signing key")
+	}
+
+	return base64.StdEncoding.EncodeToString(e.aead.Seal(nonce, nonce, privateKey, []byte(keyID))), nil
+}
+
+// Decrypt returns the private key encrypted by Encrypt
+func (e *KeyEncryptor) Decrypt(keyID, encryptedKey string) ([]byte, error) {
+	buf, err := base64.StdEncoding.DecodeString(encryptedKey)
+	if err != nil || len(buf) < e.aead.NonceSize() {
+		return nil, errors.New("unable to decrypt signing key")
+	}
+
+	nonce, ciphertext := buf[:e.aead.NonceSize()], buf[e.aead.NonceSize():]
+	privateKey, err := e.aead.Open(nil, nonce, ciphertext, []byte(keyID))
+	if err != nil {
+		return nil, errors.New("unable to decrypt signing key")
+	}
+
+	return privateKey, nil
+}
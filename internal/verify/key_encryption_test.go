@@ -0,0 +1,41 @@
+package verify
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewKeyEncryptor(t *testing.T) {
+	_, err := NewKeyEncryptor("not hex")
+	assert.EqualError(t, err, "key encryption key must be 32 hex encoded bytes")
+
+	_, err = NewKeyEncryptor(strings.Repeat("ab", 16))
+	assert.EqualError(t, err, "key encryption key must be 32 hex encoded bytes")
+}
+
+func TestKeyEncryptor(t *testing.T) {
+	encryptor, err := NewKeyEncryptor(strings.Repeat("ab", 32))
+	assert.NoError(t, err)
+
+	encrypted, err := encryptor.Encrypt("key-1", []byte("privatekey"))
+	assert.NoError(t, err)
+	assert.NotContains(t, encrypted, "privatekey")
+
+	privateKey, err := encryptor.Decrypt("key-1", encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, "privatekey", string(privateKey))
+
+	// the private key can't be used under another key ID or with another encryption key
+	_, err = encryptor.Decrypt("key-2", encrypted)
+	assert.EqualError(t, err, "unable to decrypt signing key")
+
+	otherEncryptor, err := NewKeyEncryptor(strings.Repeat("cd", 32))
+	assert.NoError(t, err)
+	_, err = otherEncryptor.Decrypt("key-1", encrypted)
+	assert.EqualError(t, err, "unable to decrypt signing key")
+
+	_, err = encryptor.Decrypt("key-1", "privatekey")
+	assert.EqualError(t, err, "unable to decrypt signing key")
+}
@@ -0,0 +1,286 @@
+package verify
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	DefaultHMACKeyLength = 64
+	DefaultRSAKeyBits    = 2048
+	// PASETOLocalKeyLength is the length of v4.local keys
+	PASETOLocalKeyLength = 32
+)
+
+var (
+	ErrorSigningKeyNotFound = errors.New("signing key not found")
+	ErrorNoActiveSigningKey = errors.New("no active signing key")
+)
+
+// SigningKey is a key in a Keyring. HS256 and v4.local keys use the same secret ([]byte) as private and public key
+type SigningKey struct {
+	KeyID      string
+	Algorithm  string
+	PrivateKey any
+	PublicKey  any
+	CreatedAt  time.Time
+	RetiresAt  *time.Time
+}
+
+// IsRetired returns true if tokens signed with the key may no longer be validated
+func (k SigningKey) IsRetired(now time.Time) bool {
+	return k.RetiresAt != nil && !now.Before(*k.RetiresAt)
+}
+
+// IsAsymmetric returns true if the public key of the signing key may be published
+func (k SigningKey) IsAsymmetric() bool {
+	return k.Algorithm == SigningMethodRS256 || k.Algorithm == SigningMethodEdDSA || k.Algorithm == SigningMethodPASETOV4Public
+}
+
+// EncodePrivateKey encodes the private key for storage. RSA and Ed25519 keys are PEM (PKCS #8) encoded and
+// HS256 and v4.local secrets are base64 encoded
+func (k SigningKey) EncodePrivateKey() ([]byte, error) {
+	if k.Algorithm == SigningMethodHS256 || k.Algorithm == SigningMethodPASETOV4Local {
+		secret, ok := k.PrivateKey.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s secret", k.Algorithm)
+		}
+
+		return []byte(base64.StdEncoding.EncodeToString(secret)), nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(k.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode private key: %w", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// NewSigningKey creates a signing key from a TokenConfig. v4.local secrets are hex encoded
+func NewSigningKey(keyID string, config TokenConfig) (SigningKey, error) {
+	switch config.SigningMethod {
+	case SigningMethodHS256, "":
+		if config.Secret == "" {
+			return SigningKey{}, errors.New("HS256 signing requires a secret")
+		}
+
+		return newHMACSigningKey(keyID, []byte(config.Secret)), nil
+	case SigningMethodRS256:
+		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(config.PrivateKeyPEM)
+		if err != nil {
+			return SigningKey{}, fmt.Errorf("unable to parse RSA private key: %w", err)
+		}
+
+		return newRSASigningKey(keyID, privateKey), nil
+	case SigningMethodEdDSA:
+		privateKey, err := jwt.ParseEdPrivateKeyFromPEM(config.PrivateKeyPEM)
+		if err != nil {
+			return SigningKey{}, fmt.Errorf("unable to parse Ed25519 private key: %w", err)
+		}
+
+		return newEd25519SigningKey(keyID, privateKey.(ed25519.PrivateKey)), nil
+	case SigningMethodPASETOV4Public:
+		privateKey, err := jwt.ParseEdPrivateKeyFromPEM(config.PrivateKeyPEM)
+		if err != nil {
+			return SigningKey{}, fmt.Errorf("unable to parse Ed25519 private key: %w", err)
+		}
+
+		return newPASETOPublicSigningKey(keyID, privateKey.(ed25519.PrivateKey)), nil
+	case SigningMethodPASETOV4Local:
+		secret, err := hex.DecodeString(config.Secret)
+		if err != nil {
+			return SigningKey{}, fmt.Errorf("unable to decode v4.local secret: %w", err)
+		}
+
+		return newPASETOLocalSigningKey(keyID, secret)
+	default:
+		return SigningKey{}, fmt.Errorf("%w: %s", ErrorUnsupportedSigningMethod, config.SigningMethod)
+	}
+}
+
+// ParseSigningKey creates a signing key from a private key that was encoded using EncodePrivateKey
+func ParseSigningKey(keyID, algorithm string, encodedPrivateKey []byte) (SigningKey, error) {
+	if algorithm == SigningMethodHS256 {
+		secret, err := base64.StdEncoding.DecodeString(string(encodedPrivateKey))
+		if err != nil {
+			return SigningKey{}, fmt.Errorf("unable to decode HS256 secret: %w", err)
+		}
+
+		return newHMACSigningKey(keyID, secret), nil
+	}
+
+	if algorithm == SigningMethodPASETOV4Local {
+		secret, err := base64.StdEncoding.DecodeString(string(encodedPrivateKey))
+		if err != nil {
+			return SigningKey{}, fmt.Errorf("unable to decode v4.local secret: %w", err)
+		}
+
+		return newPASETOLocalSigningKey(keyID, secret)
+	}
+
+	return NewSigningKey(keyID, TokenConfig{SigningMethod: algorithm, PrivateKeyPEM: encodedPrivateKey})
+}
+
+// GenerateSigningKey generates a new random key with a random key ID
+func GenerateSigningKey(algorithm string) (SigningKey, error) {
+	keyID, err := randomHex(8)
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	switch algorithm {
+	case SigningMethodHS256:
+		secret := make([]byte, DefaultHMACKeyLength)
+		if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+			return SigningKey{}, errors.New("unable to generate HS256 secret")
+		}
+
+		return newHMACSigningKey(keyID, secret), nil
+	case SigningMethodRS256:
+		privateKey, err := rsa.GenerateKey(rand.Reader, DefaultRSAKeyBits)
+		if err != nil {
+			return SigningKey{}, errors.New("unable to generate RSA key")
+		}
+
+		return newRSASigningKey(keyID, privateKey), nil
+	case SigningMethodEdDSA:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return SigningKey{}, errors.New("unable to generate Ed25519 key")
+		}
+
+		return newEd25519SigningKey(keyID, privateKey), nil
+	case SigningMethodPASETOV4Public:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return SigningKey{}, errors.New("unable to generate Ed25519 key")
+		}
+
+		return newPASETOPublicSigningKey(keyID, privateKey), nil
+	case SigningMethodPASETOV4Local:
+		secret := make([]byte, PASETOLocalKeyLength)
+		if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+			return SigningKey{}, errors.New("unable to generate v4.local secret")
+		}
+
+		return newPASETOLocalSigningKey(keyID, secret)
+	default:
+		return SigningKey{}, fmt.Errorf("%w: %s", ErrorUnsupportedSigningMethod, algorithm)
+	}
+}
+
+func newHMACSigningKey(keyID string, secret []byte) SigningKey {
+	return SigningKey{KeyID: keyID, Algorithm: SigningMethodHS256, PrivateKey: secret, PublicKey: secret}
+}
+
+func newRSASigningKey(keyID string, privateKey *rsa.PrivateKey) SigningKey {
+	return SigningKey{KeyID: keyID, Algorithm: SigningMethodRS256, PrivateKey: privateKey, PublicKey: &privateKey.PublicKey}
+}
+
+func newEd25519SigningKey(keyID string, privateKey ed25519.PrivateKey) SigningKey {
+	return SigningKey{KeyID: keyID, Algorithm: SigningMethodEdDSA, PrivateKey: privateKey, PublicKey: privateKey.Public()}
+}
+
+func newPASETOPublicSigningKey(keyID string, privateKey ed25519.PrivateKey) SigningKey {
+	return SigningKey{KeyID: keyID, Algorithm: SigningMethodPASETOV4Public, PrivateKey: privateKey, PublicKey: privateKey.Public()}
+}
+
+func newPASETOLocalSigningKey(keyID string, secret []byte) (SigningKey, error) {
+	if len(secret) != PASETOLocalKeyLength {
+		return SigningKey{}, fmt.Errorf("v4.local secrets must be %d bytes long", PASETOLocalKeyLength)
+	}
+
+	return SigningKey{KeyID: keyID, Algorithm: SigningMethodPASETOV4Local, PrivateKey: secret, PublicKey: secret}, nil
+}
+
+func randomHex(length int) (string, error) {
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", errors.New("unable to generate key id")
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// Keyring holds all the keys that can be used to validate tokens. Only the active key is used to sign new tokens.
+// A Keyring is safe for concurrent use, so it can be reloaded while requests are being served
+type Keyring struct {
+	mu          sync.RWMutex
+	keys        []SigningKey
+	activeKeyID string
+}
+
+func NewKeyring(keys []SigningKey, activeKeyID string) *Keyring {
+	return &Keyring{
+		keys:        keys,
+		activeKeyID: activeKeyID,
+	}
+}
+
+// Replace replaces all the keys in the keyring
+func (k *Keyring) Replace(keys []SigningKey, activeKeyID string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.keys = keys
+	k.activeKeyID = activeKeyID
+}
+
+// ActiveKey returns the key used to sign new tokens
+func (k *Keyring) ActiveKey() (SigningKey, error) {
+	key, err := k.Key(k.ActiveKeyID())
+	if err != nil {
+		return SigningKey{}, ErrorNoActiveSigningKey
+	}
+
+	return key, nil
+}
+
+func (k *Keyring) ActiveKeyID() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.activeKeyID
+}
+
+// Key returns the key with the given key ID. Retired keys are not returned
+func (k *Keyring) Key(keyID string) (SigningKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, key := range k.keys {
+		if key.KeyID == keyID && !key.IsRetired(time.Now()) {
+			return key, nil
+		}
+	}
+
+	return SigningKey{}, ErrorSigningKeyNotFound
+}
+
+// Keys returns all the keys that have not yet retired
+func (k *Keyring) Keys() []SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	keys := []SigningKey{}
+	for _, key := range k.keys {
+		if !key.IsRetired(time.Now()) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
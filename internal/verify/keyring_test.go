@@ -0,0 +1,86 @@
+package verify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSigningKeyErrors(t *testing.T) {
+	_, err := NewSigningKey("primary", TokenConfig{SigningMethod: SigningMethodHS256})
+	assert.Error(t, err)
+
+	_, err = NewSigningKey("primary", TokenConfig{SigningMethod: SigningMethodRS256, PrivateKeyPEM: []byte("invalid")})
+	assert.Error(t, err)
+
+	_, err = NewSigningKey("primary", TokenConfig{SigningMethod: SigningMethodPASETOV4Local, Secret: "tooshort"})
+	assert.Error(t, err)
+
+	_, err = NewSigningKey("primary", TokenConfig{SigningMethod: "none"})
+	assert.ErrorIs(t, err, ErrorUnsupportedSigningMethod)
+}
+
+func TestGenerateAndParseSigningKey(t *testing.T) {
+	for _, algorithm := range []string{SigningMethodHS256, SigningMethodRS256, SigningMethodEdDSA, SigningMethodPASETOV4Public, SigningMethodPASETOV4Local} {
+		t.Run(algorithm, func(t *testing.T) {
+			key, err := GenerateSigningKey(algorithm)
+			assert.NoError(t, err)
+			assert.Len(t, key.KeyID, 16)
+
+			encoded, err := key.EncodePrivateKey()
+			assert.NoError(t, err)
+
+			parsedKey, err := ParseSigningKey(key.KeyID, algorithm, encoded)
+			assert.NoError(t, err)
+			assert.Equal(t, key.PrivateKey, parsedKey.PrivateKey)
+			assert.Equal(t, key.PublicKey, parsedKey.PublicKey)
+		})
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	oldKey, _ := GenerateSigningKey(SigningMethodHS256)
+	newKey, _ := GenerateSigningKey(SigningMethodEdDSA)
+	keyring := NewKeyring([]SigningKey{oldKey}, oldKey.KeyID)
+
+	tokenGenerator := JWTTokenUtils{}
+	tokenGenerator.Setup(keyring, testIssuer, testAudience)
+	oldToken, err := tokenGenerator.GenerateToken(Claims{Subject: "0460d39a-9c81-48bd-86ed-7154f44ac611"}, time.Hour)
+	assert.NoError(t, err)
+
+	// rotate, tokens signed with the old key stay valid until the old key retires
+	retiresAt := time.Now().Add(time.Hour)
+	oldKey.RetiresAt = &retiresAt
+	keyring.Replace([]SigningKey{oldKey, newKey}, newKey.KeyID)
+
+	newToken, err := tokenGenerator.GenerateToken(Claims{Subject: "0460d39a-9c81-48bd-86ed-7154f44ac611"}, time.Hour)
+	assert.NoError(t, err)
+	_, err = tokenGenerator.ValidateToken(oldToken)
+	assert.NoError(t, err)
+	_, err = tokenGenerator.ValidateToken(newToken)
+	assert.NoError(t, err)
+	assert.Len(t, tokenGenerator.JWKS().Keys, 1)
+
+	// retire the old key
+	retiresAt = time.Now().Add(-time.Second)
+	oldKey.RetiresAt = &retiresAt
+	keyring.Replace([]SigningKey{oldKey, newKey}, newKey.KeyID)
+
+	_, err = tokenGenerator.ValidateToken(oldToken)
+	assert.ErrorIs(t, err, ErrorTokenValidationFailed)
+	_, err = tokenGenerator.ValidateToken(newToken)
+	assert.NoError(t, err)
+	assert.Len(t, keyring.Keys(), 1)
+}
+
+func TestKeyringWithoutActiveKey(t *testing.T) {
+	key, _ := GenerateSigningKey(SigningMethodHS256)
+	keyring := NewKeyring([]SigningKey{key}, "missing")
+
+	_, err := keyring.ActiveKey()
+	assert.ErrorIs(t, err, ErrorNoActiveSigningKey)
+
+	_, err = keyring.Key("missing")
+	assert.ErrorIs(t, err, ErrorSigningKeyNotFound)
+}
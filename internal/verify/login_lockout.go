@@ -0,0 +1,47 @@
+package verify
+
+import (
+	"auth_api/internal/models"
+	"time"
+)
+
+// LoginLockout slows down password guessing. Every failed attempt doubles the delay before the next attempt is
+// allowed, starting at BaseDelay. After MaxFailures failed attempts sign in is locked for LockoutDuration. Failed
+// attempts are forgotten when the lock expires or when there were no failed attempts for LockoutDuration
+type LoginLockout struct {
+	MaxFailures     int
+	BaseDelay       time.Duration
+	LockoutDuration time.Duration
+}
+
+// RetryAfter returns how long to wait before the next sign in attempt is allowed. Zero means it is allowed now
+func (l LoginLockout) RetryAfter(attempt *models.LoginAttempt, now time.Time) time.Duration {
+	if attempt == nil || l.IsExpired(attempt, now) {
+		return 0
+	}
+
+	if attempt.LockedUntil != nil {
+		return attempt.LockedUntil.Sub(now)
+	}
+
+	if l.BaseDelay <= 0 || attempt.Failures <= 0 {
+		return 0
+	}
+
+	delay := l.BaseDelay
+	for i := 1; i < attempt.Failures && delay < l.LockoutDuration; i++ {
+		delay *= 2
+	}
+	delay = min(delay, l.LockoutDuration)
+
+	return max(attempt.LastFailureAt.Add(delay).Sub(now), 0)
+}
+
+// IsExpired returns true if the failed attempts are forgotten
+func (l LoginLockout) IsExpired(attempt *models.LoginAttempt, now time.Time) bool {
+	if attempt.LockedUntil != nil {
+		return !now.Before(*attempt.LockedUntil)
+	}
+
+	return !now.Before(attempt.LastFailureAt.Add(l.LockoutDuration))
+}
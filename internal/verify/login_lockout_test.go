@@ -0,0 +1,41 @@
+package verify
+
+import (
+	"auth_api/internal/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginLockout(t *testing.T) {
+	now := time.Now()
+	lockedUntil := now.Add(time.Minute)
+	unlockedAt := now.Add(-time.Second)
+	lockout := LoginLockout{MaxFailures: 5, BaseDelay: time.Second, LockoutDuration: 15 * time.Minute}
+
+	tests := []struct {
+		desc       string
+		attempt    *models.LoginAttempt
+		retryAfter time.Duration
+		expired    bool
+	}{
+		{desc: "no failed attempts", attempt: nil, retryAfter: 0, expired: true},
+		{desc: "first failure", attempt: &models.LoginAttempt{Failures: 1, LastFailureAt: now}, retryAfter: time.Second},
+		{desc: "delay doubles", attempt: &models.LoginAttempt{Failures: 3, LastFailureAt: now}, retryAfter: 4 * time.Second},
+		{desc: "delay passed", attempt: &models.LoginAttempt{Failures: 3, LastFailureAt: now.Add(-5 * time.Second)}, retryAfter: 0},
+		{desc: "delay capped", attempt: &models.LoginAttempt{Failures: 30, LastFailureAt: now}, retryAfter: 15 * time.Minute},
+		{desc: "locked", attempt: &models.LoginAttempt{Failures: 5, LockedUntil: &lockedUntil, LastFailureAt: now}, retryAfter: time.Minute},
+		{desc: "lock expired", attempt: &models.LoginAttempt{Failures: 5, LockedUntil: &unlockedAt, LastFailureAt: now.Add(-15 * time.Minute)}, retryAfter: 0, expired: true},
+		{desc: "failures forgotten", attempt: &models.LoginAttempt{Failures: 4, LastFailureAt: now.Add(-16 * time.Minute)}, retryAfter: 0, expired: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			assert.Equal(t, test.retryAfter, lockout.RetryAfter(test.attempt, now))
+			if test.attempt != nil {
+				assert.Equal(t, test.expired, lockout.IsExpired(test.attempt, now))
+			}
+		})
+	}
+}
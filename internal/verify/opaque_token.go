@@ -0,0 +1,39 @@
+package verify
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+)
+
+const (
+	DefaultOpaqueTokenLength = 32
+)
+
+// GenerateOpaqueToken returns a random, url safe token that carries no claims of its own. Refresh tokens, client
+// secrets, authorization codes, session tokens and CSRF tokens are opaque tokens
+func GenerateOpaqueToken() (string, error) {
+	buf := make([]byte, DefaultOpaqueTokenLength)
+	_, err := io.ReadFull(rand.Reader, buf)
+	if err != nil {
+		return "", errors.New("unable to generate token")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashOpaqueToken returns the hex encoded SHA-256 hash of an opaque token. Opaque tokens are generated with enough
+// entropy that a slow password hash is not required. Only the hash is stored in the DB
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CompareOpaqueToken returns true if the token matches the hash. The comparison takes constant time
+func CompareOpaqueToken(tokenHash, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(tokenHash), []byte(HashOpaqueToken(token))) == 1
+}
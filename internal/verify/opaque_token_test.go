@@ -0,0 +1,28 @@
+package verify
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateOpaqueToken(t *testing.T) {
+	token1, err := GenerateOpaqueToken()
+	assert.NoError(t, err)
+
+	token2, err := GenerateOpaqueToken()
+	assert.NoError(t, err)
+
+	assert.Len(t, token1, 43)
+	assert.NotEqual(t, token1, token2)
+}
+
+func TestCompareOpaqueToken(t *testing.T) {
+	hash := HashOpaqueToken("validtoken")
+
+	assert.Len(t, hash, 64)
+	assert.Equal(t, hash, HashOpaqueToken("validtoken"))
+	assert.True(t, CompareOpaqueToken(hash, "validtoken"))
+	assert.False(t, CompareOpaqueToken(hash, "invalidtoken"))
+	assert.False(t, CompareOpaqueToken(hash, ""))
+}
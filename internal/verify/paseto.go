@@ -0,0 +1,221 @@
+package verify
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"aidanwoods.dev/go-paseto"
+)
+
+const (
+	SigningMethodPASETOV4Public = "v4.public"
+	SigningMethodPASETOV4Local  = "v4.local"
+)
+
+// IsPASETOSigningMethod returns true if tokens signed with the algorithm are PASETO tokens instead of JWTs
+func IsPASETOSigningMethod(algorithm string) bool {
+	return algorithm == SigningMethodPASETOV4Public || algorithm == SigningMethodPASETOV4Local
+}
+
+// PASETOTokenUtils issues PASETO v4 tokens (https://paseto.io) instead of JWTs. v4.public tokens are signed using
+// Ed25519 keys and v4.local tokens are encrypted using 32 byte secrets. The version and purpose in the token header
+// must match the algorithm of the key, so a token can't choose how it is validated
+type PASETOTokenUtils struct {
+	keyring  *Keyring
+	issuer   string
+	audience string
+}
+
+// pasetoFooter is the unencrypted footer of a token. It contains the key ID, so keys can be rotated
+type pasetoFooter struct {
+	KeyID string `json:"kid"`
+}
+
+// Setup configures the keys used to sign and validate tokens. Tokens are issued for, and only accepted from, the
+// issuer and audience
+func (t *PASETOTokenUtils) Setup(keyring *Keyring, issuer, audience string) error {
+	if err := validateTokenSettings(keyring, issuer, audience); err != nil {
+		return err
+	}
+
+	t.keyring = keyring
+	t.issuer = issuer
+	t.audience = audience
+	return nil
+}
+
+// GenerateToken signs or encrypts a token containing the claims using the active key. The iss, iat, nbf and exp
+// claims are always set by the token utils and the jti claim defaults to a random uuid. PASETO only supports a
+// single audience, so only the first audience of the claims is used
+func (t *PASETOTokenUtils) GenerateToken(claims Claims, expiresIn time.Duration) (string, error) {
+	key, err := t.keyring.ActiveKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := paseto.NewToken()
+	for name, value := range privateClaims(claims) {
+		if err := token.Set(name, value); err != nil {
+			return "", fmt.Errorf("unable to set %s claim: %w", name, err)
+		}
+	}
+
+	now := time.Now()
+	token.SetJti(tokenID(claims))
+	token.SetIssuer(t.issuer)
+	token.SetSubject(claims.Subject)
+	token.SetAudience(t.audience)
+	if len(claims.Audience) > 0 {
+		token.SetAudience(claims.Audience[0])
+	}
+	token.SetIssuedAt(now)
+	token.SetNotBefore(now)
+	token.SetExpiration(now.Add(expiresIn))
+
+	footer, err := json.Marshal(pasetoFooter{KeyID: key.KeyID})
+	if err != nil {
+		return "", err
+	}
+	token.SetFooter(footer)
+
+	switch key.Algorithm {
+	case SigningMethodPASETOV4Public:
+		privateKey, ok := key.PrivateKey.(ed25519.PrivateKey)
+		if !ok {
+			return "", fmt.Errorf("invalid %s key", key.Algorithm)
+		}
+
+		secretKey, err := paseto.NewV4AsymmetricSecretKeyFromEd25519(privateKey)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s key: %w", key.Algorithm, err)
+		}
+
+		return token.V4Sign(secretKey, nil), nil
+	case SigningMethodPASETOV4Local:
+		secret, ok := key.PrivateKey.([]byte)
+		if !ok {
+			return "", fmt.Errorf("invalid %s key", key.Algorithm)
+		}
+
+		symmetricKey, err := paseto.V4SymmetricKeyFromBytes(secret)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s key: %w", key.Algorithm, err)
+		}
+
+		return token.V4Encrypt(symmetricKey, nil), nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrorUnsupportedSigningMethod, key.Algorithm)
+	}
+}
+
+// ValidateToken checks the signature (or decrypts the token), issuer, audience and validity period of a token and
+// returns its claims
+func (t *PASETOTokenUtils) ValidateToken(tokenStr string) (*Claims, error) {
+	protocol := paseto.V4Public
+	if strings.HasPrefix(tokenStr, paseto.V4Local.Header()) {
+		protocol = paseto.V4Local
+	}
+
+	parser := paseto.MakeParser([]paseto.Rule{paseto.ValidAt(time.Now()), paseto.IssuedBy(t.issuer), paseto.ForAudience(t.audience)})
+
+	// the footer is authenticated together with the token, so the key ID can only be trusted once the token is valid
+	encodedFooter, err := parser.UnsafeParseFooter(protocol, tokenStr)
+	if err != nil {
+		return nil, ErrorTokenValidationFailed
+	}
+
+	var footer pasetoFooter
+	if err := json.Unmarshal(encodedFooter, &footer); err != nil {
+		return nil, ErrorTokenValidationFailed
+	}
+
+	key, err := t.keyring.Key(footer.KeyID)
+	if err != nil {
+		return nil, ErrorTokenValidationFailed
+	}
+
+	var token *paseto.Token
+	switch key.Algorithm {
+	case SigningMethodPASETOV4Public:
+		publicKey, ok := key.PublicKey.(ed25519.PublicKey)
+		if !ok {
+			return nil, ErrorTokenValidationFailed
+		}
+
+		v4PublicKey, err := paseto.NewV4AsymmetricPublicKeyFromEd25519(publicKey)
+		if err != nil {
+			return nil, ErrorTokenValidationFailed
+		}
+
+		token, err = parser.ParseV4Public(v4PublicKey, tokenStr, nil)
+		if err != nil {
+			return nil, ErrorTokenValidationFailed
+		}
+	case SigningMethodPASETOV4Local:
+		secret, ok := key.PrivateKey.([]byte)
+		if !ok {
+			return nil, ErrorTokenValidationFailed
+		}
+
+		symmetricKey, err := paseto.V4SymmetricKeyFromBytes(secret)
+		if err != nil {
+			return nil, ErrorTokenValidationFailed
+		}
+
+		token, err = parser.ParseV4Local(symmetricKey, tokenStr, nil)
+		if err != nil {
+			return nil, ErrorTokenValidationFailed
+		}
+	default:
+		// e.g. a HS256 key may not be used to validate a PASETO token
+		return nil, ErrorTokenValidationFailed
+	}
+
+	return claimsFromPASETO(token), nil
+}
+
+// JWKS returns the v4.public keys as Ed25519 JWKs, so other services can validate tokens. The keys are published
+// without an alg, because PASETO is not a JWS algorithm. v4.local keys are never published
+func (t *PASETOTokenUtils) JWKS() JWKSet {
+	keySet := JWKSet{Keys: []JWK{}}
+
+	for _, key := range t.keyring.Keys() {
+		if key.Algorithm != SigningMethodPASETOV4Public {
+			continue
+		}
+
+		jwk, err := NewJWK(key.PublicKey, "")
+		if err != nil {
+			continue
+		}
+
+		jwk.KeyID = key.KeyID
+		keySet.Keys = append(keySet.Keys, jwk)
+	}
+
+	return keySet
+}
+
+// SigningMethods returns the PASETO v4 purposes
+func (t *PASETOTokenUtils) SigningMethods() []string {
+	return []string{SigningMethodPASETOV4Public, SigningMethodPASETOV4Local}
+}
+
+func claimsFromPASETO(token *paseto.Token) *Claims {
+	claims := &Claims{}
+	claims.ID, _ = token.GetJti()
+	claims.Issuer, _ = token.GetIssuer()
+	claims.Subject, _ = token.GetSubject()
+	if audience, err := token.GetAudience(); err == nil {
+		claims.Audience = []string{audience}
+	}
+	claims.IssuedAt, _ = token.GetIssuedAt()
+	claims.NotBefore, _ = token.GetNotBefore()
+	claims.ExpiresAt, _ = token.GetExpiration()
+	claims.setPrivateClaims(token.Claims())
+
+	return claims
+}
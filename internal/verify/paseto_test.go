@@ -0,0 +1,133 @@
+package verify
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPASETOLocalSecret = "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f"
+
+func newPASETOTestKeyrings(t *testing.T) map[string]*Keyring {
+	t.Helper()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate Ed25519 key: %s", err)
+	}
+
+	return map[string]*Keyring{
+		SigningMethodPASETOV4Public: newTestKeyring(t, TokenConfig{SigningMethod: SigningMethodPASETOV4Public, PrivateKeyPEM: encodePrivateKey(t, edKey)}),
+		SigningMethodPASETOV4Local:  newTestKeyring(t, TokenConfig{SigningMethod: SigningMethodPASETOV4Local, Secret: testPASETOLocalSecret}),
+	}
+}
+
+func TestPASETOGenerateToken(t *testing.T) {
+	for purpose, keyring := range newPASETOTestKeyrings(t) {
+		t.Run(purpose, func(t *testing.T) {
+			tokenGenerator := PASETOTokenUtils{}
+			err := tokenGenerator.Setup(keyring, testIssuer, testAudience)
+			assert.NoError(t, err)
+
+			tokenStr, err := tokenGenerator.GenerateToken(Claims{
+				Subject:      "0460d39a-9c81-48bd-86ed-7154f44ac611",
+				Email:        "verified@gmail.com",
+				Role:         "ADMIN",
+				TokenVersion: 3,
+				Custom:       map[string]any{"tenant": "acme", "iss": "someoneelse"},
+			}, time.Hour)
+			assert.NoError(t, err)
+			assert.True(t, strings.HasPrefix(tokenStr, purpose+"."))
+
+			claims, err := tokenGenerator.ValidateToken(tokenStr)
+			assert.NoError(t, err)
+			assert.Equal(t, "0460d39a-9c81-48bd-86ed-7154f44ac611", claims.Subject)
+			assert.NotEmpty(t, claims.ID)
+			assert.Equal(t, testIssuer, claims.Issuer)
+			assert.Equal(t, []string{testAudience}, claims.Audience)
+			assert.WithinDuration(t, time.Now(), claims.IssuedAt, time.Minute)
+			assert.WithinDuration(t, time.Now().Add(time.Hour), claims.ExpiresAt, time.Minute)
+			assert.Equal(t, "verified@gmail.com", claims.Email)
+			assert.Equal(t, "ADMIN", claims.Role)
+			assert.Equal(t, 3, claims.TokenVersion)
+			assert.Equal(t, map[string]any{"tenant": "acme"}, claims.Custom)
+		})
+	}
+}
+
+func TestPASETOValidateToken(t *testing.T) {
+	keyrings := newPASETOTestKeyrings(t)
+
+	publicGenerator := PASETOTokenUtils{}
+	publicGenerator.Setup(keyrings[SigningMethodPASETOV4Public], testIssuer, testAudience)
+	publicToken, _ := publicGenerator.GenerateToken(Claims{Subject: "0460d39a-9c81-48bd-86ed-7154f44ac611"}, time.Hour)
+	expiredToken, _ := publicGenerator.GenerateToken(Claims{Subject: "0460d39a-9c81-48bd-86ed-7154f44ac611"}, -time.Hour)
+	clientToken, _ := publicGenerator.GenerateToken(Claims{Subject: "0460d39a-9c81-48bd-86ed-7154f44ac611", Audience: []string{"someclient"}}, time.Hour)
+
+	localGenerator := PASETOTokenUtils{}
+	localGenerator.Setup(keyrings[SigningMethodPASETOV4Local], testIssuer, testAudience)
+	localToken, _ := localGenerator.GenerateToken(Claims{Subject: "0460d39a-9c81-48bd-86ed-7154f44ac611"}, time.Hour)
+
+	otherIssuerGenerator := PASETOTokenUtils{}
+	otherIssuerGenerator.Setup(keyrings[SigningMethodPASETOV4Public], "otherissuer", testAudience)
+	otherIssuerToken, _ := otherIssuerGenerator.GenerateToken(Claims{Subject: "0460d39a-9c81-48bd-86ed-7154f44ac611"}, time.Hour)
+
+	jwtGenerator := JWTTokenUtils{}
+	jwtGenerator.Setup(newTestKeyring(t, TokenConfig{Secret: "secret"}), testIssuer, testAudience)
+	jwtToken, _ := jwtGenerator.GenerateToken(Claims{Subject: "0460d39a-9c81-48bd-86ed-7154f44ac611"}, time.Hour)
+
+	tests := []struct {
+		desc  string
+		token string
+	}{
+		{desc: "empty token", token: ""},
+		{desc: "jwt", token: jwtToken},
+		{desc: "expired token", token: expiredToken},
+		{desc: "other issuer", token: otherIssuerToken},
+		{desc: "other audience", token: clientToken},
+		{desc: "v4.local token for a v4.public key", token: localToken},
+		{desc: "tampered token", token: publicToken[:len(publicToken)-60] + strings.Repeat("A", 10) + publicToken[len(publicToken)-50:]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := publicGenerator.ValidateToken(test.token)
+			assert.ErrorIs(t, err, ErrorTokenValidationFailed)
+		})
+	}
+
+	_, err := publicGenerator.ValidateToken(publicToken)
+	assert.NoError(t, err)
+}
+
+func TestPASETOKeyRotation(t *testing.T) {
+	oldKey, _ := GenerateSigningKey(SigningMethodPASETOV4Local)
+	newKey, _ := GenerateSigningKey(SigningMethodPASETOV4Public)
+	keyring := NewKeyring([]SigningKey{oldKey}, oldKey.KeyID)
+
+	tokenGenerator := PASETOTokenUtils{}
+	tokenGenerator.Setup(keyring, testIssuer, testAudience)
+	oldToken, err := tokenGenerator.GenerateToken(Claims{Subject: "0460d39a-9c81-48bd-86ed-7154f44ac611"}, time.Hour)
+	assert.NoError(t, err)
+	assert.Empty(t, tokenGenerator.JWKS().Keys)
+
+	keyring.Replace([]SigningKey{oldKey, newKey}, newKey.KeyID)
+
+	newToken, err := tokenGenerator.GenerateToken(Claims{Subject: "0460d39a-9c81-48bd-86ed-7154f44ac611"}, time.Hour)
+	assert.NoError(t, err)
+	_, err = tokenGenerator.ValidateToken(oldToken)
+	assert.NoError(t, err)
+	_, err = tokenGenerator.ValidateToken(newToken)
+	assert.NoError(t, err)
+
+	// only the v4.public key is published, without a JWS algorithm
+	jwks := tokenGenerator.JWKS()
+	assert.Len(t, jwks.Keys, 1)
+	assert.Equal(t, "OKP", jwks.Keys[0].KeyType)
+	assert.Equal(t, newKey.KeyID, jwks.Keys[0].KeyID)
+	assert.Empty(t, jwks.Keys[0].Algorithm)
+}
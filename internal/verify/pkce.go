@@ -0,0 +1,40 @@
+package verify
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+)
+
+const (
+	CodeChallengeMethodS256 = "S256"
+)
+
+// IsCodeVerifier checks the length and characters of a PKCE code verifier (RFC 7636 section 4.1)
+func IsCodeVerifier(verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	for _, c := range verifier {
+		isUnreserved := (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '.' || c == '_' || c == '~'
+		if !isUnreserved {
+			return false
+		}
+	}
+
+	return true
+}
+
+// VerifyCodeChallenge returns true if the S256 code challenge was derived from the code verifier
+// (RFC 7636 section 4.6)
+func VerifyCodeChallenge(verifier, challenge string) bool {
+	if !IsCodeVerifier(verifier) {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
@@ -0,0 +1,34 @@
+package verify
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	// example from RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	tests := []struct {
+		desc      string
+		verifier  string
+		challenge string
+		want      bool
+	}{
+		{desc: "valid verifier", verifier: verifier, challenge: challenge, want: true},
+		{desc: "other verifier", verifier: strings.Repeat("a", 43), challenge: challenge, want: false},
+		{desc: "plain challenge", verifier: verifier, challenge: verifier, want: false},
+		{desc: "verifier too short", verifier: "dBjftJeZ4CVP", challenge: challenge, want: false},
+		{desc: "verifier too long", verifier: strings.Repeat("a", 129), challenge: challenge, want: false},
+		{desc: "invalid characters", verifier: strings.Repeat("a", 42) + "+", challenge: challenge, want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			assert.Equal(t, test.want, VerifyCodeChallenge(test.verifier, test.challenge))
+		})
+	}
+}
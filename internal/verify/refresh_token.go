@@ -0,0 +1,13 @@
+package verify
+
+type RefreshTokenGenerator interface {
+	GenerateRefreshToken() (string, error)
+}
+
+type OpaqueRefreshTokenGenerator struct {
+}
+
+// GenerateRefreshToken returns an opaque token. Only the hash of the token (HashOpaqueToken) is stored in the DB
+func (g OpaqueRefreshTokenGenerator) GenerateRefreshToken() (string, error) {
+	return GenerateOpaqueToken()
+}
@@ -0,0 +1,13 @@
+CREATE TABLE if not exists public.refresh_tokens (
+  token_id uuid PRIMARY KEY,
+  family_id uuid not null,
+  user_id uuid not null references users(user_id) on delete cascade,
+  token_hash varchar(64) not null,
+  expires_at TIMESTAMP not null,
+  revoked_at TIMESTAMP,
+  replaced_by uuid,
+  created_at TIMESTAMP not null DEFAULT now(),
+  UNIQUE(token_hash)
+);
+
+CREATE INDEX if not exists idx_refresh_tokens_family_id ON refresh_tokens(family_id);
@@ -0,0 +1 @@
+drop table if exists signing_keys;
\ No newline at end of file
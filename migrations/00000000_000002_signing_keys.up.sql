@@ -0,0 +1,7 @@
+CREATE TABLE if not exists public.signing_keys (
+  key_id varchar(64) PRIMARY KEY,
+  algorithm varchar(20) not null check(algorithm in ('HS256', 'RS256', 'EdDSA')),
+  private_key text not null,
+  retires_at TIMESTAMP,
+  created_at TIMESTAMP not null DEFAULT now()
+);
@@ -0,0 +1,7 @@
+CREATE TABLE if not exists public.revoked_tokens (
+  token_id varchar(255) PRIMARY KEY,
+  expires_at TIMESTAMP not null,
+  created_at TIMESTAMP not null DEFAULT now()
+);
+
+CREATE INDEX if not exists idx_revoked_tokens_expires_at ON revoked_tokens(expires_at);
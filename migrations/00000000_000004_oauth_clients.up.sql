@@ -0,0 +1,8 @@
+CREATE TABLE if not exists public.oauth_clients (
+  client_id uuid PRIMARY KEY,
+  client_secret_hash varchar(64) not null,
+  name varchar(255) not null,
+  scopes varchar(1024) not null DEFAULT '',
+  created_at TIMESTAMP not null DEFAULT now(),
+  updated_at TIMESTAMP not null DEFAULT now()
+);
@@ -0,0 +1,2 @@
+drop table if exists authorization_codes;
+alter table oauth_clients drop column if exists redirect_uris;
\ No newline at end of file
@@ -0,0 +1,15 @@
+ALTER TABLE public.oauth_clients ADD COLUMN if not exists redirect_uris varchar(2048) not null DEFAULT '';
+
+CREATE TABLE if not exists public.authorization_codes (
+  code_hash varchar(64) PRIMARY KEY,
+  client_id uuid not null references oauth_clients(client_id) on delete cascade,
+  user_id uuid not null references users(user_id) on delete cascade,
+  redirect_uri varchar(2048) not null DEFAULT '',
+  scope varchar(1024) not null DEFAULT '',
+  code_challenge varchar(128) not null,
+  expires_at TIMESTAMP not null,
+  used_at TIMESTAMP,
+  created_at TIMESTAMP not null DEFAULT now()
+);
+
+CREATE INDEX if not exists idx_authorization_codes_expires_at ON authorization_codes(expires_at);
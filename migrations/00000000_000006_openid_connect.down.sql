@@ -0,0 +1 @@
+alter table authorization_codes drop column if exists nonce, drop column if exists auth_time;
\ No newline at end of file
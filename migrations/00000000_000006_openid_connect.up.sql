@@ -0,0 +1,2 @@
+ALTER TABLE public.authorization_codes ADD COLUMN if not exists nonce varchar(512) not null DEFAULT '';
+ALTER TABLE public.authorization_codes ADD COLUMN if not exists auth_time TIMESTAMP not null DEFAULT now();
@@ -0,0 +1,11 @@
+CREATE TABLE if not exists public.sessions (
+  session_id uuid PRIMARY KEY,
+  token_hash varchar(64) not null,
+  csrf_token_hash varchar(64) not null,
+  user_id uuid not null references users(user_id) on delete cascade,
+  expires_at TIMESTAMP not null,
+  created_at TIMESTAMP not null DEFAULT now(),
+  UNIQUE(token_hash)
+);
+
+CREATE INDEX if not exists idx_sessions_expires_at ON sessions(expires_at);
@@ -0,0 +1 @@
+alter table users drop column if exists token_version;
\ No newline at end of file
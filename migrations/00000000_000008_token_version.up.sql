@@ -0,0 +1 @@
+ALTER TABLE public.users ADD COLUMN if not exists token_version int not null DEFAULT 0;
@@ -0,0 +1 @@
+drop table if exists impersonations;
\ No newline at end of file
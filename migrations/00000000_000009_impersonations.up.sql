@@ -0,0 +1,10 @@
+CREATE TABLE if not exists public.impersonations (
+  impersonation_id uuid PRIMARY KEY,
+  actor varchar(255) not null,
+  user_id uuid not null,
+  token_id varchar(255) not null,
+  expires_at TIMESTAMP not null,
+  created_at TIMESTAMP not null DEFAULT now()
+);
+
+CREATE INDEX if not exists idx_impersonations_user_id ON impersonations(user_id);
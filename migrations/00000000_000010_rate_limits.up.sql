@@ -0,0 +1,8 @@
+CREATE TABLE if not exists public.rate_limits (
+  key varchar(512) PRIMARY KEY,
+  tokens double precision not null,
+  allowed boolean not null,
+  updated_at TIMESTAMP not null DEFAULT now()
+);
+
+CREATE INDEX if not exists idx_rate_limits_updated_at ON rate_limits(updated_at);
@@ -0,0 +1 @@
+drop table if exists login_attempts;
\ No newline at end of file
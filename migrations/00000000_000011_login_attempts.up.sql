@@ -0,0 +1,6 @@
+CREATE TABLE if not exists public.login_attempts (
+  key varchar(512) PRIMARY KEY,
+  failures int not null,
+  locked_until TIMESTAMP,
+  last_failure_at TIMESTAMP not null DEFAULT now()
+);
@@ -0,0 +1,11 @@
+CREATE TABLE if not exists public.idempotency_keys (
+  key varchar(512) PRIMARY KEY,
+  request_hash varchar(64) not null,
+  status int,
+  content_type varchar(255) not null DEFAULT '',
+  response bytea,
+  expires_at TIMESTAMP not null,
+  created_at TIMESTAMP not null DEFAULT now()
+);
+
+CREATE INDEX if not exists idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
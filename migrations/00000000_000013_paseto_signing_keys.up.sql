@@ -0,0 +1,3 @@
+ALTER TABLE public.signing_keys DROP CONSTRAINT if exists signing_keys_algorithm_check;
+
+ALTER TABLE public.signing_keys ADD CONSTRAINT signing_keys_algorithm_check check(algorithm in ('HS256', 'RS256', 'EdDSA', 'v4.public', 'v4.local'));
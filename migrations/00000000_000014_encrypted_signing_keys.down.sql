@@ -0,0 +1,3 @@
+DELETE FROM signing_keys;
+
+ALTER TABLE public.signing_keys RENAME COLUMN encrypted_private_key TO private_key;
\ No newline at end of file
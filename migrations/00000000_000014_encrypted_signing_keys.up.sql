@@ -0,0 +1,5 @@
+-- signing keys used to be stored in plaintext. They can't be encrypted here, so they are removed and the configured
+-- key is active again until the next rotation
+DELETE FROM signing_keys;
+
+ALTER TABLE public.signing_keys RENAME COLUMN private_key TO encrypted_private_key;
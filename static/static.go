@@ -0,0 +1,7 @@
+// Package static contains the pages that are rendered by the api
+package static
+
+import "embed"
+
+//go:embed authorize.html
+var Files embed.FS
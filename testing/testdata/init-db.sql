@@ -1,4 +1,4 @@
-TRUNCATE TABLE public.users;
+TRUNCATE TABLE public.users CASCADE;
 TRUNCATE TABLE public.verification;
 
 INSERT INTO public.users (user_id, email,"password",status,role,created_at,updated_at) VALUES
@@ -23,6 +23,10 @@ INSERT INTO public.users (user_id, email,"password",status,role,created_at,updat
 	 ('74a8ebde-489d-4c04-843b-8f22f19bae0e', 'resetpassword@gmail.com','validpass','verify_reset','USER','2024-07-23 13:33:08.951815','2024-07-23 13:33:08.951815');
 INSERT INTO public.users (user_id, email,"password",status,role,created_at,updated_at) VALUES
 	 ('74a8ebde-489d-4c04-843b-8f22f19bae0f', 'resetpasswordrequest@gmail.com','validpass','active','USER','2024-07-23 13:33:08.951815','2024-07-23 13:33:08.951815');
+INSERT INTO public.users (user_id, email,"password",status,role,created_at,updated_at) VALUES
+	 ('d1b6c7a2-4f3e-4a5b-9c8d-7e6f5a4b3c21', 'admin@gmail.com','validpass','active','ADMIN','2024-07-23 13:33:08.951815','2024-07-23 13:33:08.951815');
+INSERT INTO public.users (user_id, email,"password",status,role,created_at,updated_at) VALUES
+	 ('d1b6c7a2-4f3e-4a5b-9c8d-7e6f5a4b3c22', 'formeradmin@gmail.com','validpass','active','USER','2024-07-23 13:33:08.951815','2024-07-23 13:33:08.951815');
 
 
 INSERT INTO public.verification (email,verification_type,verification_code,expires_at,attempts_remaining,created_at,updated_at) VALUES
@@ -33,3 +37,37 @@ INSERT INTO public.verification (email,verification_type,verification_code,expir
 	 ('expiredverification@gmail.com','account','ABCDEF','2000-07-24 15:33:36.106086',0,'2024-07-23 13:33:36.107427','2024-07-23 13:33:36.107427');
 INSERT INTO public.verification (email,verification_type,verification_code,expires_at,attempts_remaining,created_at,updated_at) VALUES
 	 ('resetpassword@gmail.com','reset','ABCDEF','2099-07-24 15:33:36.106086',3,'2024-07-23 13:33:36.107427','2024-07-23 13:33:36.107427');
+
+INSERT INTO public.refresh_tokens (token_id,family_id,user_id,token_hash,expires_at,revoked_at,replaced_by) VALUES
+	 ('5b1f4d3e-52a4-4f39-9d43-3c5c0d4c6a01','9a3c1b7e-0f4d-4d6b-8a51-2f6e9b1c7d01','74a8ebde-489d-4c04-843b-8f22f19bae0b',encode(sha256('validrefreshtoken'::bytea), 'hex'),'2099-07-24 15:33:36.106086',NULL,NULL);
+INSERT INTO public.refresh_tokens (token_id,family_id,user_id,token_hash,expires_at,revoked_at,replaced_by) VALUES
+	 ('5b1f4d3e-52a4-4f39-9d43-3c5c0d4c6a02','9a3c1b7e-0f4d-4d6b-8a51-2f6e9b1c7d02','74a8ebde-489d-4c04-843b-8f22f19bae0b',encode(sha256('rotatedrefreshtoken'::bytea), 'hex'),'2099-07-24 15:33:36.106086','2024-07-23 13:33:36.107427','5b1f4d3e-52a4-4f39-9d43-3c5c0d4c6a03');
+INSERT INTO public.refresh_tokens (token_id,family_id,user_id,token_hash,expires_at,revoked_at,replaced_by) VALUES
+	 ('5b1f4d3e-52a4-4f39-9d43-3c5c0d4c6a03','9a3c1b7e-0f4d-4d6b-8a51-2f6e9b1c7d02','74a8ebde-489d-4c04-843b-8f22f19bae0b',encode(sha256('rotatedfamilyrefreshtoken'::bytea), 'hex'),'2099-07-24 15:33:36.106086',NULL,NULL);
+INSERT INTO public.refresh_tokens (token_id,family_id,user_id,token_hash,expires_at,revoked_at,replaced_by) VALUES
+	 ('5b1f4d3e-52a4-4f39-9d43-3c5c0d4c6a04','9a3c1b7e-0f4d-4d6b-8a51-2f6e9b1c7d04','74a8ebde-489d-4c04-843b-8f22f19bae0b',encode(sha256('expiredrefreshtoken'::bytea), 'hex'),'2000-07-24 15:33:36.106086',NULL,NULL);
+INSERT INTO public.refresh_tokens (token_id,family_id,user_id,token_hash,expires_at,revoked_at,replaced_by) VALUES
+	 ('5b1f4d3e-52a4-4f39-9d43-3c5c0d4c6a05','9a3c1b7e-0f4d-4d6b-8a51-2f6e9b1c7d05','7b8c7b8f-b2d7-4045-af58-a49db6d47a81',encode(sha256('inactiverefreshtoken'::bytea), 'hex'),'2099-07-24 15:33:36.106086',NULL,NULL);
+
+INSERT INTO public.oauth_clients (client_id,client_secret_hash,name,scopes) VALUES
+	 ('3f1c2b8a-5d4e-4f6a-9b7c-1e2d3c4b5a60',encode(sha256('validclientsecret'::bytea), 'hex'),'backend jobs','jobs:read jobs:write');
+INSERT INTO public.oauth_clients (client_id,client_secret_hash,name,scopes,redirect_uris) VALUES
+	 ('3f1c2b8a-5d4e-4f6a-9b7c-1e2d3c4b5a62','','spa','openid profile email','https://app.example.com/callback');
+
+INSERT INTO public.authorization_codes (code_hash,client_id,user_id,redirect_uri,scope,code_challenge,expires_at) VALUES
+	 (encode(sha256('validauthorizationcode'::bytea), 'hex'),'3f1c2b8a-5d4e-4f6a-9b7c-1e2d3c4b5a62','74a8ebde-489d-4c04-843b-8f22f19bae0b','','profile','E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM','2099-07-24 15:33:36.106086');
+INSERT INTO public.authorization_codes (code_hash,client_id,user_id,redirect_uri,scope,code_challenge,expires_at) VALUES
+	 (encode(sha256('expiredauthorizationcode'::bytea), 'hex'),'3f1c2b8a-5d4e-4f6a-9b7c-1e2d3c4b5a62','74a8ebde-489d-4c04-843b-8f22f19bae0b','','profile','E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM','2000-07-24 15:33:36.106086');
+INSERT INTO public.authorization_codes (code_hash,client_id,user_id,redirect_uri,scope,code_challenge,expires_at) VALUES
+	 (encode(sha256('wrongverifierauthorizationcode'::bytea), 'hex'),'3f1c2b8a-5d4e-4f6a-9b7c-1e2d3c4b5a62','74a8ebde-489d-4c04-843b-8f22f19bae0b','','profile','E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM','2099-07-24 15:33:36.106086');
+INSERT INTO public.authorization_codes (code_hash,client_id,user_id,redirect_uri,scope,code_challenge,expires_at) VALUES
+	 (encode(sha256('redirecturiauthorizationcode'::bytea), 'hex'),'3f1c2b8a-5d4e-4f6a-9b7c-1e2d3c4b5a62','74a8ebde-489d-4c04-843b-8f22f19bae0b','https://app.example.com/callback','profile','E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM','2099-07-24 15:33:36.106086');
+INSERT INTO public.authorization_codes (code_hash,client_id,user_id,redirect_uri,scope,code_challenge,expires_at) VALUES
+	 (encode(sha256('otherclientauthorizationcode'::bytea), 'hex'),'3f1c2b8a-5d4e-4f6a-9b7c-1e2d3c4b5a62','74a8ebde-489d-4c04-843b-8f22f19bae0b','','profile','E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM','2099-07-24 15:33:36.106086');
+INSERT INTO public.authorization_codes (code_hash,client_id,user_id,redirect_uri,scope,code_challenge,nonce,expires_at) VALUES
+	 (encode(sha256('openidauthorizationcode'::bytea), 'hex'),'3f1c2b8a-5d4e-4f6a-9b7c-1e2d3c4b5a62','74a8ebde-489d-4c04-843b-8f22f19bae0b','','openid email','E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM','n-0S6_WzA2Mj','2099-07-24 15:33:36.106086');
+
+INSERT INTO public.sessions (session_id,token_hash,csrf_token_hash,user_id,expires_at) VALUES
+	 ('c6a1f0de-2b7c-4f4e-9d0a-5e8b1c2d3e01',encode(sha256('validsessiontoken'::bytea), 'hex'),encode(sha256('validcsrftoken'::bytea), 'hex'),'74a8ebde-489d-4c04-843b-8f22f19bae0b','2099-07-24 15:33:36.106086');
+INSERT INTO public.sessions (session_id,token_hash,csrf_token_hash,user_id,expires_at) VALUES
+	 ('c6a1f0de-2b7c-4f4e-9d0a-5e8b1c2d3e02',encode(sha256('expiredsessiontoken'::bytea), 'hex'),encode(sha256('validcsrftoken'::bytea), 'hex'),'74a8ebde-489d-4c04-843b-8f22f19bae0b','2000-07-24 15:33:36.106086');